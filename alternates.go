@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Layouts of the alternate sources of a part in release BOMs
+const (
+	// alternates are not listed
+	alternatesNone = ""
+	// Manufacturer2, MPN2, Manufacturer3, MPN3, ... columns
+	alternatesColumns = "columns"
+	// one row per alternate below the line of the part, with a Priority column
+	alternatesRows = "rows"
+)
+
+// alternatesLayout is how release BOMs list the alternate sources of a part:
+// the partmaster rows of its IPN that lost on Priority.
+var alternatesLayout = alternatesNone
+
+func checkAlternatesLayout(layout string) error {
+	switch layout {
+	case alternatesNone, alternatesColumns, alternatesRows:
+		return nil
+	}
+	return fmt.Errorf("release.alternates must be %v or %v, not %v",
+		alternatesColumns, alternatesRows, layout)
+}
+
+// findAlternates returns the sources of pn in the partmaster other than
+// winner, in order of priority. Sources without an MPN, or with the MPN of one
+// already listed, are skipped.
+func (p *partmaster) findAlternates(pn ipn, winner *partmasterLine) []*partmasterLine {
+	found := byPriority{}
+	for _, l := range *p {
+		if l.IPN == pn && l != winner {
+			found = append(found, l)
+		}
+	}
+	sort.Stable(found)
+
+	ret := []*partmasterLine{}
+	seen := map[string]bool{winner.MPN: true}
+	for _, l := range found {
+		if l.MPN == "" || seen[l.MPN] {
+			continue
+		}
+		seen[l.MPN] = true
+		ret = append(ret, l)
+	}
+
+	return ret
+}
+
+// withoutAlternates returns the BOM without the rows of alternate sources a
+// release BOM has in the rows layout. An alternate row follows the line of its
+// part and has no quantity.
+func (b bom) withoutAlternates() bom {
+	ret := bom{}
+	for i, l := range b {
+		if i > 0 && l.IPN == b[i-1].IPN && l.Qty == 0 {
+			continue
+		}
+		ret = append(ret, l)
+	}
+	return ret
+}
+
+// saveBomCSV writes a release BOM, with the alternate sources of its parts in
+// the configured layout
+func saveBomCSV(filename string, b bom) error {
+	records, err := b.records()
+	if err != nil {
+		return err
+	}
+
+	if alternatesLayout == alternatesNone || len(records) == 0 {
+		return writeCSVRecords(filename, records)
+	}
+
+	header := records[0]
+	col := map[string]int{}
+	for i, h := range header {
+		col[h] = i
+	}
+
+	out := [][]string{}
+
+	switch alternatesLayout {
+	case alternatesColumns:
+		count := 0
+		for _, l := range b {
+			count = max(count, len(l.alternates))
+		}
+		for i := 2; i < count+2; i++ {
+			header = append(header, fmt.Sprintf("Manufacturer%v", i), fmt.Sprintf("MPN%v", i))
+		}
+		out = append(out, header)
+
+		for i, l := range b {
+			row := records[i+1]
+			for j := 0; j < count; j++ {
+				if j < len(l.alternates) {
+					row = append(row, l.alternates[j].Manufacturer, l.alternates[j].MPN)
+				} else {
+					row = append(row, "", "")
+				}
+			}
+			out = append(out, row)
+		}
+
+	case alternatesRows:
+		out = append(out, append(header, "Priority"))
+
+		for i, l := range b {
+			row := records[i+1]
+			out = append(out, append(row, strconv.Itoa(l.priority)))
+
+			for _, a := range l.alternates {
+				alt := append([]string{}, row...)
+				alt[col["Qty"]] = ""
+				alt[col["Ref"]] = ""
+				alt[col["Manufacturer"]] = a.Manufacturer
+				alt[col["MPN"]] = a.MPN
+				alt[col["Datasheet"]] = a.Datasheet
+				alt[col["Checked"]] = a.Checked
+				out = append(out, append(alt, strconv.Itoa(a.Priority)))
+			}
+		}
+	}
+
+	return writeCSVRecords(filename, out)
+}
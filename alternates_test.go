@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// releaseAlternates releases the boards of releaseTree with a second and third
+// source for the resistors, and returns the released PCA BOM.
+func releaseAlternates(t *testing.T, layout string) string {
+	t.Helper()
+	pmDir := setupReleaseTree(t)
+
+	writeTree(t, pmDir, map[string]string{
+		"res.csv": `IPN,Description,Manufacturer,MPN,Priority,Checked
+RES-001-1002,10k 0603,Vishay,CRCW060310K0,2,Y
+RES-001-1002,10k 0603,Yageo,RC0603FR-0710KL,0,Y
+RES-001-1002,10k 0603,Panasonic,ERJ-3EKF1002V,1,
+RES-001-1002,10k 0603,Yageo,RC0603FR-0710KL,3,
+`,
+	})
+
+	alternatesLayout = layout
+	t.Cleanup(func() { alternatesLayout = alternatesNone })
+
+	release(t, "PCB-019-0001", pmDir)
+	pcaDir := release(t, "PCA-019-0000", pmDir)
+	asyDir := release(t, "ASY-001-0000", pmDir)
+
+	// the alternates do not add to the roll-up BOM of the product
+	all := bom{}
+	err := loadCSV(filepath.Join(asyDir, "ASY-001-0000-all.csv"), &all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range all.withoutAlternates() {
+		if l.IPN == "RES-001-1002" && (l.Qty != 4 || l.MPN != "RC0603FR-0710KL") {
+			t.Errorf("%v: roll-up line %+v", layout, l)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(pcaDir, "PCA-019-0000.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestAlternatesColumns(t *testing.T) {
+	data := releaseAlternates(t, alternatesColumns)
+
+	lines := strings.Split(data, "\n")
+	if !strings.HasSuffix(lines[0], ",Manufacturer2,MPN2,Manufacturer3,MPN3") {
+		t.Errorf("header: %v", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], ",,,,") || !strings.HasPrefix(lines[1], "PCB-019-0001,") {
+		t.Errorf("PCB line: %v", lines[1])
+	}
+	if !strings.HasSuffix(lines[2], ",Panasonic,ERJ-3EKF1002V,Vishay,CRCW060310K0") {
+		t.Errorf("resistor line: %v", lines[2])
+	}
+}
+
+func TestAlternatesRows(t *testing.T) {
+	data := releaseAlternates(t, alternatesRows)
+
+	b := bom{}
+	err := loadCSV(filepath.Join("elec", "PCA-019-0000", "PCA-019-0000.csv"), &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"PCB-019-0001 1 BoardHouse PCB-019-0001",
+		"RES-001-1002 2 Yageo RC0603FR-0710KL",
+		"RES-001-1002 0 Panasonic ERJ-3EKF1002V",
+		"RES-001-1002 0 Vishay CRCW060310K0",
+	}
+	if len(b) != len(exp) {
+		t.Fatalf("BOM has %v lines, want %v:\n%v", len(b), len(exp), data)
+	}
+	for i, l := range b {
+		line := fmt.Sprintf("%v %v %v %v", l.IPN, l.Qty, l.Manufacturer, l.MPN)
+		if line != exp[i] {
+			t.Errorf("line %v = %v, want %v", i, line, exp[i])
+		}
+	}
+
+	lines := strings.Split(data, "\n")
+	if !strings.HasSuffix(lines[0], ",Priority") || !strings.HasSuffix(lines[2], ",0") ||
+		!strings.HasSuffix(lines[3], ",1") || !strings.HasSuffix(lines[4], ",2") {
+		t.Errorf("priorities not listed:\n%v", data)
+	}
+
+	if len(b.withoutAlternates()) != 2 {
+		t.Errorf("withoutAlternates() = %v", b.withoutAlternates())
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Formats of release archives
+const (
+	archiveZip   = "zip"
+	archiveTarGz = "tar.gz"
+)
+
+// How release archives hold the releases of sub-assemblies
+const (
+	// the files of the sub-assembly releases are in the archive
+	archiveSubsInclude = "include"
+	// the links to the sub-assembly releases are in the archive
+	archiveSubsReference = "reference"
+)
+
+// releaseArchive is the archive format set with -archive, which overrides the
+// one in the release script
+var releaseArchive = ""
+
+// archiveTime is the time of every entry of a release archive, so the same
+// release always gives the same archive
+var archiveTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// relArchive packs a release dir into an archive next to it. In the release
+// script it is either just the format, or a map with these options.
+type relArchive struct {
+	// Format is zip or tar.gz
+	Format string `yaml:"format"`
+	// SubReleases is include or reference, include by default
+	SubReleases string `yaml:"subReleases"`
+}
+
+func (a *relArchive) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var format string
+	if err := unmarshal(&format); err == nil {
+		*a = relArchive{Format: format}
+		return a.validate()
+	}
+
+	type plain relArchive
+	err := unmarshal((*plain)(a))
+	if err != nil {
+		return err
+	}
+	return a.validate()
+}
+
+// validate checks the archive options, so a mistake is reported before the
+// release does anything. An empty format is no archive.
+func (a relArchive) validate() error {
+	switch a.Format {
+	case "", archiveZip, archiveTarGz:
+	default:
+		return fmt.Errorf("archive format must be %v or %v, not %v", archiveZip, archiveTarGz, a.Format)
+	}
+
+	switch a.SubReleases {
+	case "", archiveSubsInclude, archiveSubsReference:
+	default:
+		return fmt.Errorf("archive subReleases must be %v or %v, not %v",
+			archiveSubsInclude, archiveSubsReference, a.SubReleases)
+	}
+
+	return nil
+}
+
+// archive returns the archive options of the release, with the format set by
+// -archive if any. The release has no archive if the format is empty.
+func (rs *relScript) archive() relArchive {
+	a := rs.Archive
+	if releaseArchive != "" {
+		a.Format = releaseArchive
+	}
+	return a
+}
+
+// archiveEntry is a file, directory, or link in an archive
+type archiveEntry struct {
+	name string
+	path string
+	mode fs.FileMode
+	link string
+	size int64
+}
+
+// archiveEntries lists what goes in the archive of a release dir, in a stable
+// order, with names below the IPN of the release. The manifest of the release
+// is left out, as it records the archive. A sub-release used by several parts
+// is included below each of them, as in the release dir.
+func archiveEntries(relPn, releaseDir, subs string) ([]archiveEntry, error) {
+	ret := []archiveEntry{}
+	// the dirs being walked, to stop at a link back to one of them
+	onPath := map[string]bool{}
+
+	var walk func(dir, prefix string, top bool) error
+	walk = func(dir, prefix string, top bool) error {
+		root := resolvePath(dir)
+		if onPath[root] {
+			return nil
+		}
+		onPath[root] = true
+		defer delete(onPath, root)
+
+		return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			name := prefix
+			if rel != "." {
+				name += "/" + filepath.ToSlash(rel)
+			}
+
+			switch {
+			case d.Type()&fs.ModeSymlink != 0:
+				if subs == archiveSubsReference {
+					link, err := os.Readlink(path)
+					if err != nil {
+						return err
+					}
+					ret = append(ret, archiveEntry{name: name, mode: fs.ModeSymlink | 0777,
+						link: filepath.ToSlash(link)})
+					return nil
+				}
+				return walk(path, name, false)
+			case d.IsDir():
+				ret = append(ret, archiveEntry{name: name + "/", mode: fs.ModeDir | 0755})
+			case top && rel == manifestFile:
+			default:
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				mode := fs.FileMode(0644)
+				if info.Mode()&0111 != 0 {
+					mode = 0755
+				}
+				ret = append(ret, archiveEntry{name: name, path: path, mode: mode, size: info.Size()})
+			}
+			return nil
+		})
+	}
+
+	err := walk(releaseDir, relPn, true)
+	return ret, err
+}
+
+// writeArchive writes the archive of a release dir next to it, and returns its
+// path
+func writeArchive(relPn, releaseDir string, a relArchive) (string, error) {
+	err := a.validate()
+	if err != nil {
+		return "", err
+	}
+
+	subs := a.SubReleases
+	if subs == "" {
+		subs = archiveSubsInclude
+	}
+
+	write := writeZip
+	if a.Format == archiveTarGz {
+		write = writeTarGz
+	}
+
+	entries, err := archiveEntries(relPn, releaseDir, subs)
+	if err != nil {
+		return "", err
+	}
+
+	archivePath := filepath.Join(filepath.Dir(releaseDir), relPn+"."+a.Format)
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return "", err
+	}
+
+	err = write(f, entries)
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+
+	return archivePath, f.Close()
+}
+
+// releaseArchivePath returns the path of the archive the manifest of a release
+// dir records, or "" if there is none
+func releaseArchivePath(releaseDir string) string {
+	m, err := loadManifest(releaseDir)
+	if err != nil || m.Archive == nil {
+		return ""
+	}
+	return filepath.Join(releaseDir, filepath.FromSlash(m.Archive.Path))
+}
+
+func copyFileTo(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func writeZip(out io.Writer, entries []archiveEntry) error {
+	zw := zip.NewWriter(out)
+
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate, Modified: archiveTime}
+		h.SetMode(e.mode)
+		if e.mode.IsDir() {
+			h.Method = zip.Store
+		}
+
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case e.mode&fs.ModeSymlink != 0:
+			_, err = io.WriteString(w, e.link)
+		case e.path != "":
+			err = copyFileTo(w, e.path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeTarGz(out io.Writer, entries []archiveEntry) error {
+	gw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gw)
+
+	for _, e := range entries {
+		h := &tar.Header{
+			Name:    e.name,
+			Mode:    int64(e.mode.Perm()),
+			ModTime: archiveTime,
+		}
+		switch {
+		case e.mode.IsDir():
+			h.Typeflag = tar.TypeDir
+		case e.mode&fs.ModeSymlink != 0:
+			h.Typeflag = tar.TypeSymlink
+			h.Linkname = e.link
+		default:
+			h.Typeflag = tar.TypeReg
+			h.Size = e.size
+		}
+
+		err := tw.WriteHeader(h)
+		if err != nil {
+			return err
+		}
+
+		if h.Typeflag == tar.TypeReg {
+			err = copyFileTo(tw, e.path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		return err
+	}
+	return gw.Close()
+}
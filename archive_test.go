@@ -0,0 +1,223 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReleaseArchiveZip(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+
+	releaseArchive = archiveZip
+	t.Cleanup(func() { releaseArchive = "" })
+
+	release(t, "PCB-019-0001", pmDir)
+	release(t, "PCA-019-0000", pmDir)
+	asyDir := release(t, "ASY-001-0000", pmDir)
+
+	archivePath := filepath.Join(filepath.Dir(asyDir), "ASY-001-0000.zip")
+	first, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the archive does not depend on when the files were written
+	later := time.Now().Add(time.Hour)
+	err = os.Chtimes(filepath.Join("elec", "PCA-019-0000", "PCA-019-0000.csv"), later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release(t, "ASY-001-0000", pmDir)
+	second, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Error("archive changed when released again")
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(second), int64(len(second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if !f.Modified.Equal(archiveTime) {
+			t.Errorf("%v modified %v, want %v", f.Name, f.Modified, archiveTime)
+		}
+	}
+	exp := []string{
+		"ASY-001-0000/",
+		"ASY-001-0000/ASY-001-0000-all.csv",
+		"ASY-001-0000/ASY-001-0000-tree.csv",
+		"ASY-001-0000/ASY-001-0000-tree.md",
+		"ASY-001-0000/ASY-001-0000.csv",
+		"ASY-001-0000/CHANGELOG-all.md",
+		"ASY-001-0000/CHANGELOG.md",
+		"ASY-001-0000/PCA-019-0000/",
+		"ASY-001-0000/PCA-019-0000/CHANGELOG-all.md",
+		"ASY-001-0000/PCA-019-0000/CHANGELOG.md",
+		"ASY-001-0000/PCA-019-0000/PCA-019-0000.csv",
+		"ASY-001-0000/PCA-019-0000/PCB-019-0001/",
+		"ASY-001-0000/PCA-019-0000/PCB-019-0001/RELEASE_NOTES.md",
+		"ASY-001-0000/PCA-019-0000/PCB-019-0001/manifest.yml",
+		"ASY-001-0000/PCA-019-0000/RELEASE_NOTES.md",
+		"ASY-001-0000/PCA-019-0000/manifest.yml",
+		"ASY-001-0000/RELEASE_NOTES.md",
+	}
+	if !reflect.DeepEqual(names, exp) {
+		t.Errorf("archive entries:\n%v\nwant\n%v", strings.Join(names, "\n"), strings.Join(exp, "\n"))
+	}
+
+	m, err := loadManifest(asyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Archive == nil || m.Archive.Path != "../ASY-001-0000.zip" || m.Archive.Size != int64(len(second)) {
+		t.Errorf("wrong manifest archive: %+v", m.Archive)
+	}
+
+	problems, err := verifyRelease(asyDir)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("verifyRelease() = %v, %v, want no problems", problems, err)
+	}
+
+	err = os.WriteFile(archivePath, []byte("altered"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err = verifyRelease(asyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expProblems := []string{filepath.Join(filepath.Dir(resolvePath(asyDir)), "ASY-001-0000.zip") + ": archive modified"}
+	if !reflect.DeepEqual(problems, expProblems) {
+		t.Errorf("verifyRelease() = %v, want %v", problems, expProblems)
+	}
+}
+
+func TestReleaseArchiveTarGz(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+	writeTree(t, ".", map[string]string{
+		"ASY-001.yml": `archive:
+  format: tar.gz
+  subReleases: reference
+`,
+	})
+
+	release(t, "PCB-019-0001", pmDir)
+	release(t, "PCA-019-0000", pmDir)
+	asyDir := release(t, "ASY-001-0000", pmDir)
+
+	f, err := os.Open(filepath.Join(filepath.Dir(asyDir), "ASY-001-0000.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gr.ModTime.IsZero() || gr.Name != "" {
+		t.Errorf("gzip header has name %q and time %v", gr.Name, gr.ModTime)
+	}
+
+	tr := tar.NewReader(gr)
+	names := []string{}
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+		if !h.ModTime.Equal(archiveTime) || h.Uid != 0 || h.Gid != 0 {
+			t.Errorf("%v: time %v, uid %v, gid %v", h.Name, h.ModTime, h.Uid, h.Gid)
+		}
+		if h.Name == "ASY-001-0000/PCA-019-0000" &&
+			(h.Typeflag != tar.TypeSymlink || h.Linkname != "../elec/PCA-019-0000") {
+			t.Errorf("sub-assembly is %v to %v, want a link", h.Typeflag, h.Linkname)
+		}
+	}
+	exp := []string{
+		"ASY-001-0000/",
+		"ASY-001-0000/ASY-001-0000-all.csv",
+		"ASY-001-0000/ASY-001-0000-tree.csv",
+		"ASY-001-0000/ASY-001-0000-tree.md",
+		"ASY-001-0000/ASY-001-0000.csv",
+		"ASY-001-0000/CHANGELOG-all.md",
+		"ASY-001-0000/CHANGELOG.md",
+		"ASY-001-0000/PCA-019-0000",
+		"ASY-001-0000/RELEASE_NOTES.md",
+	}
+	if !reflect.DeepEqual(names, exp) {
+		t.Errorf("archive entries:\n%v\nwant\n%v", strings.Join(names, "\n"), strings.Join(exp, "\n"))
+	}
+}
+
+func TestReleaseArchiveSharedSub(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+	// the product uses the bare board directly as well as on the assembly
+	writeTree(t, ".", map[string]string{
+		"ASY-001.csv": "IPN,Qty\nPCA-019-0000,2\nPCB-019-0001,1\nSCR-002-0002,4\n",
+	})
+
+	release(t, "PCB-019-0001", pmDir)
+	release(t, "PCA-019-0000", pmDir)
+	asyDir := release(t, "ASY-001-0000", pmDir)
+
+	entries, err := archiveEntries("ASY-001-0000", asyDir, archiveSubsInclude)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, e := range entries {
+		names[e.name] = true
+	}
+	for _, n := range []string{
+		"ASY-001-0000/PCB-019-0001/RELEASE_NOTES.md",
+		"ASY-001-0000/PCA-019-0000/PCB-019-0001/RELEASE_NOTES.md",
+	} {
+		if !names[n] {
+			t.Errorf("archive has no %v", n)
+		}
+	}
+}
+
+func TestReleaseArchiveInvalid(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+	writeTree(t, ".", map[string]string{
+		"ASY-001.yml": `hooks:
+  - touch hooked
+archive:
+  format: rar
+`,
+	})
+
+	var relLog strings.Builder
+	_, err := processRelease("ASY-001-0000", &relLog, pmDir)
+	if err == nil || !strings.Contains(err.Error(), "archive format must be zip or tar.gz, not rar") {
+		t.Errorf("release with an invalid archive format: %v", err)
+	}
+	if e, _ := exists("hooked"); e {
+		t.Error("hooks ran before the archive format was checked")
+	}
+
+	for _, a := range []relArchive{{Format: "7z"}, {Format: archiveZip, SubReleases: "all"}} {
+		if err := a.validate(); err == nil {
+			t.Errorf("%+v is valid", a)
+		}
+	}
+}
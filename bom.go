@@ -1,12 +1,17 @@
 package main
 
 import (
+	"encoding/csv"
 	"fmt"
 	"log"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+
+	"github.com/gocarina/gocsv"
+	"github.com/samber/lo"
 )
 
 type bomLine struct {
@@ -22,6 +27,12 @@ type bomLine struct {
 	Vendor       string  `csv:"Vendor" yaml:"vendor"`
 	Datasheet    string  `csv:"Datasheet" yaml:"datasheet"`
 	Checked      string  `csv:"Checked" yaml:"checked"`
+	Notes        string  `csv:"Notes" yaml:"notes"`
+
+	// the partmaster priority of the source above, and the other sources of
+	// the part, filled in by mergePartmaster when alternates are listed
+	priority   int
+	alternates []*partmasterLine
 }
 
 func (bl *bomLine) String() string {
@@ -40,17 +51,32 @@ func (bl *bomLine) String() string {
 		bl.Checked)
 }
 
-func (bl *bomLine) removeRef(ref string) {
-	refs := strings.Split(bl.Ref, " ")
+// refFields splits reference designators, which may be separated by spaces
+// or commas
+func refFields(refs string) []string {
+	return strings.FieldsFunc(refs, func(c rune) bool {
+		return c == ' ' || c == ','
+	})
+}
+
+// removeRefs removes the reference designators for which match is true, and
+// returns them. The quantity is the number of references left, unless none
+// were removed, as lines such as glue have a quantity but no references.
+func (bl *bomLine) removeRefs(match func(string) bool) []string {
 	refsOut := []string{}
-	for _, r := range refs {
-		r = strings.Trim(r, " ")
-		if r != ref && r != "" {
+	removed := []string{}
+	for _, r := range refFields(bl.Ref) {
+		if match(r) {
+			removed = append(removed, r)
+		} else {
 			refsOut = append(refsOut, r)
 		}
 	}
-	bl.Ref = strings.Join(refsOut, " ")
-	bl.Qty = float64(len(refsOut))
+	if len(removed) > 0 {
+		bl.Ref = strings.Join(refsOut, " ")
+		bl.Qty = float64(len(refsOut))
+	}
+	return removed
 }
 
 func sortReferenceDesignators(input string) string {
@@ -105,13 +131,74 @@ func (b bom) String() string {
 	return ret
 }
 
-// merge can be used to merge partmaster attributes into a BOM
-func (b *bom) mergePartmaster(p partmaster, logErr func(string)) {
+// records returns the CSV records of the BOM, header first. The Notes column
+// is left out if no line has a note, so BOMs without notes keep the columns
+// they always had.
+func (b bom) records() ([][]string, error) {
+	data, err := gocsv.MarshalString(b)
+	if err != nil {
+		return nil, err
+	}
+	records, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 || lo.SomeBy(b, func(l *bomLine) bool { return l.Notes != "" }) {
+		return records, nil
+	}
+	idx := lo.IndexOf(records[0], "Notes")
+	if idx < 0 {
+		return records, nil
+	}
+	for i, r := range records {
+		records[i] = append(r[:idx:idx], r[idx+1:]...)
+	}
+	return records, nil
+}
+
+// saveSourceBomCSV writes a BOM that is not a release BOM, such as one
+// imported from KiCad, without alternate sources
+func saveSourceBomCSV(filename string, b bom) error {
+	records, err := b.records()
+	if err != nil {
+		return err
+	}
+	return writeCSVRecords(filename, records)
+}
+
+// bomProblem is a line of a BOM that does not check out against the
+// partmaster
+type bomProblem struct {
+	BOM     string
+	IPN     ipn
+	Line    int
+	Problem string
+}
+
+func formatBomProblems(problems []bomProblem) string {
+	var out strings.Builder
+	w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BOM\tLine\tIPN\tProblem")
+	for _, p := range problems {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", p.BOM, p.Line, p.IPN, p.Problem)
+	}
+	w.Flush()
+	return out.String()
+}
+
+// mergePartmaster fills in the purchasing information of each BOM line from
+// the partmaster. It returns the lines with a part that is not in the
+// partmaster, a purchased part without an MPN, or a part not checked.
+func (b *bom) mergePartmaster(p partmaster, logErr func(string)) []bomProblem {
+	problems := []bomProblem{}
+
 	// populate MPN info in our BOM
 	for i, l := range *b {
 		pmPart, err := p.findPart(l.IPN)
 		if err != nil {
 			logErr(fmt.Sprintf("Error finding part (%v:%v) on bom line #%v in pm: %v\n", l.CmpName, l.IPN, i+2, err))
+			problems = append(problems, bomProblem{"", l.IPN, i + 2, "not in partmaster"})
 			continue
 		}
 		l.Manufacturer = pmPart.Manufacturer
@@ -119,41 +206,101 @@ func (b *bom) mergePartmaster(p partmaster, logErr func(string)) {
 		l.Datasheet = pmPart.Datasheet
 		l.Checked = pmPart.Checked
 		l.Description = pmPart.Description
+		if alternatesLayout != alternatesNone {
+			l.priority = pmPart.Priority
+			l.alternates = p.findAlternates(l.IPN, pmPart)
+		}
+
+		if isOurs, _ := l.IPN.isOurIPN(); !isOurs && l.MPN == "" {
+			problems = append(problems, bomProblem{"", l.IPN, i + 2, "no MPN"})
+		}
+		if !strings.EqualFold(l.Checked, "Y") {
+			problems = append(problems, bomProblem{"", l.IPN, i + 2, "not checked"})
+		}
 	}
+
+	return problems
 }
 
 func (b *bom) copy() bom {
 	ret := make([]*bomLine, len(*b))
 
 	for i, l := range *b {
-		ret[i] = &(*l)
+		n := *l
+		ret[i] = &n
 	}
 
 	return ret
 }
 
-func (b *bom) processOurIPN(pn ipn, qty float64) error {
-	log.Println("processing our IPN: ", pn, qty)
-
-	// check if BOM exists
+// loadSubBom loads the BOM of a sub-assembly from its release package
+func loadSubBom(pn ipn) (bom, error) {
 	bomPath, err := findFile(pn.String() + ".csv")
 	if err != nil {
-		return fmt.Errorf("Error finding sub assy BOM: %v", err)
+		return nil, fmt.Errorf("Error finding sub assy BOM: %v", err)
 	}
 
 	subBom := bom{}
 
 	err = loadCSV(bomPath, &subBom)
 	if err != nil {
-		return fmt.Errorf("Error parsing CSV for %v: %v", pn, err)
+		return nil, fmt.Errorf("Error parsing CSV for %v: %v", pn, err)
+	}
+
+	return subBom.withoutAlternates(), nil
+}
+
+// maxBomDepth limits how many levels of sub-assemblies are expanded. 0 means
+// no limit.
+var maxBomDepth = 0
+
+// expandPath returns path, the chain of assemblies being expanded from the top
+// level down, with sub-assembly pn added. It fails if pn is already in the path,
+// as the BOMs would then expand forever, or if the chain is deeper than
+// maxBomDepth.
+func expandPath(path []ipn, pn ipn) ([]ipn, error) {
+	ret := append(append([]ipn{}, path...), pn)
+
+	if lo.Contains(path, pn) {
+		return nil, fmt.Errorf("BOM cycle: %v", formatBomPath(ret))
+	}
+
+	if maxBomDepth > 0 && len(ret) > maxBomDepth {
+		return nil, fmt.Errorf("BOM is more than %v levels deep: %v", maxBomDepth, formatBomPath(ret))
+	}
+
+	return ret, nil
+}
+
+func formatBomPath(path []ipn) string {
+	s := make([]string, len(path))
+	for i, pn := range path {
+		s[i] = pn.String()
+	}
+	return strings.Join(s, " -> ")
+}
+
+// processOurIPN adds the lines of sub-assembly pn, and of its sub-assemblies,
+// to the BOM. path is the chain of assemblies above pn.
+func (b *bom) processOurIPN(pn ipn, qty float64, path []ipn) error {
+	log.Println("processing our IPN: ", pn, qty)
+
+	path, err := expandPath(path, pn)
+	if err != nil {
+		return err
+	}
+
+	subBom, err := loadSubBom(pn)
+	if err != nil {
+		return err
 	}
 
 	for _, l := range subBom {
 		isSub, _ := l.IPN.hasBOM()
 		if isSub {
-			err := b.processOurIPN(l.IPN, l.Qty*qty)
+			err := b.processOurIPN(l.IPN, l.Qty*qty, path)
 			if err != nil {
-				return fmt.Errorf("Error processing sub %v: %v", l.IPN, err)
+				return err
 			}
 		}
 		n := *l
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gocarina/gocsv"
+)
+
+// bomDiffLine is the difference between two BOMs for one IPN
+type bomDiffLine struct {
+	Change        string  `csv:"Change"`
+	IPN           ipn     `csv:"IPN"`
+	Description   string  `csv:"Description"`
+	QtyA          float64 `csv:"Qty A"`
+	QtyB          float64 `csv:"Qty B"`
+	RefsAdded     string  `csv:"Refs Added"`
+	RefsRemoved   string  `csv:"Refs Removed"`
+	ManufacturerA string  `csv:"Manufacturer A"`
+	ManufacturerB string  `csv:"Manufacturer B"`
+	MPNA          string  `csv:"MPN A"`
+	MPNB          string  `csv:"MPN B"`
+}
+
+// refMove is a reference designator that is on a different IPN in BOM B
+type refMove struct {
+	Ref  string
+	From ipn
+	To   ipn
+}
+
+// bomDiff lists what changed from BOM A to BOM B
+type bomDiff struct {
+	A     string
+	B     string
+	Lines []bomDiffLine
+	Moves []refMove
+}
+
+// bomDiffSource is a BOM to diff: its lines by IPN, with the lines of an IPN
+// that is on the BOM more than once combined
+type bomDiffSource map[ipn]*bomLine
+
+func newBomDiffSource(b bom) bomDiffSource {
+	ret := bomDiffSource{}
+	for _, l := range b {
+		e, ok := ret[l.IPN]
+		if !ok {
+			c := *l
+			ret[l.IPN] = &c
+			continue
+		}
+		e.Qty += l.Qty
+		e.Ref = strings.TrimSpace(e.Ref + " " + l.Ref)
+	}
+	return ret
+}
+
+// refSet returns the reference designators of a line, which may be separated
+// by spaces or commas
+func refSet(refs string) map[string]bool {
+	ret := map[string]bool{}
+	for _, r := range refFields(refs) {
+		ret[r] = true
+	}
+	return ret
+}
+
+// refsNotIn returns the references in a that are not in b, sorted
+func refsNotIn(a, b map[string]bool) string {
+	ret := []string{}
+	for r := range a {
+		if !b[r] {
+			ret = append(ret, r)
+		}
+	}
+	return sortReferenceDesignators(strings.Join(ret, " "))
+}
+
+// diffBoms compares BOM a to BOM b. nameA and nameB are used in reports.
+func diffBoms(nameA string, a bom, nameB string, b bom) bomDiff {
+	ret := bomDiff{A: nameA, B: nameB, Lines: []bomDiffLine{}, Moves: []refMove{}}
+
+	srcA := newBomDiffSource(a)
+	srcB := newBomDiffSource(b)
+
+	ipns := []ipn{}
+	for pn := range srcA {
+		ipns = append(ipns, pn)
+	}
+	for pn := range srcB {
+		if _, ok := srcA[pn]; !ok {
+			ipns = append(ipns, pn)
+		}
+	}
+	sort.Slice(ipns, func(i, j int) bool { return ipns[i] < ipns[j] })
+
+	// where each reference designator is in A and B, to find moves
+	refsA := map[string]ipn{}
+	refsB := map[string]ipn{}
+
+	for _, pn := range ipns {
+		la, inA := srcA[pn]
+		lb, inB := srcB[pn]
+
+		d := bomDiffLine{IPN: pn}
+		setA := map[string]bool{}
+		setB := map[string]bool{}
+
+		if inA {
+			d.QtyA = la.Qty
+			d.Description = la.Description
+			d.ManufacturerA = la.Manufacturer
+			d.MPNA = la.MPN
+			setA = refSet(la.Ref)
+			for r := range setA {
+				refsA[r] = pn
+			}
+		}
+
+		if inB {
+			d.QtyB = lb.Qty
+			d.Description = lb.Description
+			d.ManufacturerB = lb.Manufacturer
+			d.MPNB = lb.MPN
+			setB = refSet(lb.Ref)
+			for r := range setB {
+				refsB[r] = pn
+			}
+		}
+
+		d.RefsAdded = refsNotIn(setB, setA)
+		d.RefsRemoved = refsNotIn(setA, setB)
+
+		switch {
+		case !inA:
+			d.Change = "added"
+		case !inB:
+			d.Change = "removed"
+		case d.QtyA != d.QtyB || d.RefsAdded != "" || d.RefsRemoved != "" ||
+			d.ManufacturerA != d.ManufacturerB || d.MPNA != d.MPNB:
+			d.Change = "changed"
+		default:
+			continue
+		}
+
+		ret.Lines = append(ret.Lines, d)
+	}
+
+	refs := []string{}
+	for r := range refsB {
+		if from, ok := refsA[r]; ok && from != refsB[r] {
+			refs = append(refs, r)
+		}
+	}
+	for _, r := range strings.Fields(sortReferenceDesignators(strings.Join(refs, " "))) {
+		ret.Moves = append(ret.Moves, refMove{Ref: r, From: refsA[r], To: refsB[r]})
+	}
+
+	return ret
+}
+
+// loadDiffBom loads a BOM to diff. arg is either the path of a BOM CSV file, or
+// the IPN of a release, in which case the BOM in the release dir is used, or
+// the -all.csv roll-up BOM if all is set.
+func loadDiffBom(arg string, all bool) (bom, error) {
+	b := bom{}
+
+	if strings.HasSuffix(strings.ToLower(arg), ".csv") {
+		err := loadCSV(arg, &b)
+		return b.withoutAlternates(), err
+	}
+
+	pn, err := newIpn(arg)
+	if err != nil {
+		return b, fmt.Errorf("%v is neither a CSV file nor an IPN", arg)
+	}
+
+	dir, err := findDir(pn.String())
+	if err != nil {
+		return b, fmt.Errorf("release %v not found", pn)
+	}
+
+	name := pn.String() + ".csv"
+	if all {
+		name = pn.String() + "-all.csv"
+	}
+
+	path := filepath.Join(dir, name)
+	if e, _ := exists(path); !e {
+		return b, fmt.Errorf("release %v has no %v", pn, name)
+	}
+
+	err = loadCSV(path, &b)
+	return b.withoutAlternates(), err
+}
+
+// change formats a value that may have changed from a to b
+func change(a, b string) string {
+	if a == b {
+		return a
+	}
+	return a + " -> " + b
+}
+
+func (d bomDiff) text() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "BOM diff %v -> %v\n", d.A, d.B)
+
+	if len(d.Lines) == 0 {
+		out.WriteString("No differences\n")
+		return out.String()
+	}
+
+	for _, kind := range []string{"added", "removed", "changed"} {
+		first := true
+		for _, l := range d.Lines {
+			if l.Change != kind {
+				continue
+			}
+			if first {
+				fmt.Fprintf(&out, "\n%v%v:\n", strings.ToUpper(kind[:1]), kind[1:])
+				first = false
+			}
+
+			switch kind {
+			case "added":
+				fmt.Fprintf(&out, "  %v: qty %v", l.IPN, l.QtyB)
+				if l.RefsAdded != "" {
+					fmt.Fprintf(&out, ", refs %v", l.RefsAdded)
+				}
+				if l.ManufacturerB != "" || l.MPNB != "" {
+					fmt.Fprintf(&out, ", %v %v", l.ManufacturerB, l.MPNB)
+				}
+			case "removed":
+				fmt.Fprintf(&out, "  %v: qty %v", l.IPN, l.QtyA)
+				if l.RefsRemoved != "" {
+					fmt.Fprintf(&out, ", refs %v", l.RefsRemoved)
+				}
+			default:
+				fmt.Fprintf(&out, "  %v:", l.IPN)
+				if l.QtyA != l.QtyB {
+					fmt.Fprintf(&out, " qty %v -> %v", l.QtyA, l.QtyB)
+				}
+				if l.RefsAdded != "" {
+					fmt.Fprintf(&out, " +refs %v", l.RefsAdded)
+				}
+				if l.RefsRemoved != "" {
+					fmt.Fprintf(&out, " -refs %v", l.RefsRemoved)
+				}
+				if l.ManufacturerA != l.ManufacturerB {
+					fmt.Fprintf(&out, " manufacturer %v -> %v", l.ManufacturerA, l.ManufacturerB)
+				}
+				if l.MPNA != l.MPNB {
+					fmt.Fprintf(&out, " MPN %v -> %v", l.MPNA, l.MPNB)
+				}
+			}
+			if l.Description != "" {
+				fmt.Fprintf(&out, " (%v)", l.Description)
+			}
+			out.WriteString("\n")
+		}
+	}
+
+	if len(d.Moves) > 0 {
+		out.WriteString("\nMoved references:\n")
+		for _, m := range d.Moves {
+			fmt.Fprintf(&out, "  %v: %v -> %v\n", m.Ref, m.From, m.To)
+		}
+	}
+
+	return out.String()
+}
+
+func (d bomDiff) csv() (string, error) {
+	return gocsv.MarshalString(d.Lines)
+}
+
+func (d bomDiff) markdown() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "# BOM diff %v -> %v\n\n", markdownEscape(d.A), markdownEscape(d.B))
+
+	if len(d.Lines) == 0 {
+		out.WriteString("No differences\n")
+		return out.String()
+	}
+
+	out.WriteString("| Change | IPN | Description | Qty | Refs added | Refs removed | Manufacturer | MPN |\n")
+	out.WriteString("| ------ | --- | ----------- | --- | ---------- | ------------ | ------------ | --- |\n")
+	for _, l := range d.Lines {
+		qty := change(fmt.Sprint(l.QtyA), fmt.Sprint(l.QtyB))
+		mfr := change(l.ManufacturerA, l.ManufacturerB)
+		mpn := change(l.MPNA, l.MPNB)
+		switch l.Change {
+		case "added":
+			qty, mfr, mpn = fmt.Sprint(l.QtyB), l.ManufacturerB, l.MPNB
+		case "removed":
+			qty, mfr, mpn = fmt.Sprint(l.QtyA), l.ManufacturerA, l.MPNA
+		}
+		fmt.Fprintf(&out, "| %v | %v | %v | %v | %v | %v | %v | %v |\n",
+			l.Change, l.IPN, markdownEscape(l.Description), qty,
+			l.RefsAdded, l.RefsRemoved, markdownEscape(mfr), markdownEscape(mpn))
+	}
+
+	if len(d.Moves) > 0 {
+		out.WriteString("\n## Moved references\n\n")
+		out.WriteString("| Ref | From | To |\n")
+		out.WriteString("| --- | ---- | -- |\n")
+		for _, m := range d.Moves {
+			fmt.Fprintf(&out, "| %v | %v | %v |\n", m.Ref, m.From, m.To)
+		}
+	}
+
+	return out.String()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffBoms(t *testing.T) {
+	a := bom{
+		{IPN: "CAP-000-1001", Qty: 2, Ref: "C1 C2", Manufacturer: "Murata", MPN: "GRM1"},
+		{IPN: "RES-000-1002", Qty: 3, Ref: "R1 R2 R3", Manufacturer: "Yageo", MPN: "RC1"},
+		{IPN: "SCR-002-0002", Qty: 4},
+		{IPN: "DIO-000-0001", Qty: 1, Ref: "D1"},
+	}
+	b := bom{
+		{IPN: "CAP-000-1001", Qty: 2, Ref: "C1 C2", Manufacturer: "Murata", MPN: "GRM1"},
+		{IPN: "RES-000-1002", Qty: 2, Ref: "R1 R2", Manufacturer: "Vishay", MPN: "CRCW1"},
+		{IPN: "RES-000-4701", Qty: 2, Ref: "R3 R4", Description: "4.7k"},
+		{IPN: "SCR-002-0002", Qty: 6},
+	}
+
+	d := diffBoms("A", a, "B", b)
+
+	exp := []bomDiffLine{
+		{Change: "removed", IPN: "DIO-000-0001", QtyA: 1, RefsRemoved: "D1"},
+		{Change: "changed", IPN: "RES-000-1002", QtyA: 3, QtyB: 2, RefsRemoved: "R3",
+			ManufacturerA: "Yageo", ManufacturerB: "Vishay", MPNA: "RC1", MPNB: "CRCW1"},
+		{Change: "added", IPN: "RES-000-4701", Description: "4.7k", QtyB: 2, RefsAdded: "R3 R4"},
+		{Change: "changed", IPN: "SCR-002-0002", QtyA: 4, QtyB: 6},
+	}
+	if !reflect.DeepEqual(d.Lines, exp) {
+		t.Errorf("diff lines:\n%+v\nwant\n%+v", d.Lines, exp)
+	}
+
+	expMoves := []refMove{{Ref: "R3", From: "RES-000-1002", To: "RES-000-4701"}}
+	if !reflect.DeepEqual(d.Moves, expMoves) {
+		t.Errorf("moves = %+v, want %+v", d.Moves, expMoves)
+	}
+
+	expText := `BOM diff A -> B
+
+Added:
+  RES-000-4701: qty 2, refs R3 R4 (4.7k)
+
+Removed:
+  DIO-000-0001: qty 1, refs D1
+
+Changed:
+  RES-000-1002: qty 3 -> 2 -refs R3 manufacturer Yageo -> Vishay MPN RC1 -> CRCW1
+  SCR-002-0002: qty 4 -> 6
+
+Moved references:
+  R3: RES-000-1002 -> RES-000-4701
+`
+	if d.text() != expText {
+		t.Errorf("text:\n%v\nwant\n%v", d.text(), expText)
+	}
+
+	md := d.markdown()
+	if !strings.Contains(md, "| changed | RES-000-1002 |  | 3 -> 2 |  | R3 | Yageo -> Vishay | RC1 -> CRCW1 |\n") ||
+		!strings.Contains(md, "| R3 | RES-000-1002 | RES-000-4701 |\n") {
+		t.Errorf("markdown:\n%v", md)
+	}
+
+	csv, err := d.csv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(csv, "Change,IPN,Description,Qty A,Qty B,Refs Added,Refs Removed,") ||
+		!strings.Contains(csv, "\nadded,RES-000-4701,4.7k,0,2,R3 R4,,,,,\n") {
+		t.Errorf("csv:\n%v", csv)
+	}
+
+	if d := diffBoms("A", a, "A", a); d.text() != "BOM diff A -> A\nNo differences\n" {
+		t.Errorf("diff of a BOM with itself:\n%v", d.text())
+	}
+}
+
+func TestDiffReleases(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+
+	release(t, "PCB-019-0001", pmDir)
+	release(t, "PCA-019-0000", pmDir)
+	release(t, "ASY-001-0000", pmDir)
+
+	writeTree(t, ".", map[string]string{
+		"ASY-001.csv":  "IPN,Qty\nPCA-019-0000,3\nSCR-002-0002,4\n",
+		"CHANGELOG.md": "# Changelog\n\n## [ASY-001-0001]\n\n- three boards\n",
+	})
+	release(t, "ASY-001-0001", pmDir)
+
+	for _, all := range []bool{false, true} {
+		a, err := loadDiffBom("ASY-001-0000", all)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := loadDiffBom("ASY-001-0001", all)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d := diffBoms("ASY-001-0000", a, "ASY-001-0001", b)
+		changed := []string{}
+		for _, l := range d.Lines {
+			changed = append(changed, l.IPN.String())
+		}
+
+		// the roll-up BOM also has the parts of the boards
+		exp := []string{"PCA-019-0000"}
+		if all {
+			exp = []string{"PCA-019-0000", "PCB-019-0001", "RES-001-1002"}
+		}
+		if !reflect.DeepEqual(changed, exp) {
+			t.Errorf("all=%v: changed lines %v, want %v", all, changed, exp)
+		}
+	}
+
+	if _, err := loadDiffBom("ASY-001-0009", false); err == nil {
+		t.Error("loading a missing release did not fail")
+	}
+}
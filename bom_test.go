@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBomCycle(t *testing.T) {
+	initCSV()
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"ASY-001-0000/ASY-001-0000.csv": "IPN,Qty\nPCA-019-0000,1\n",
+		"PCA-019-0000/PCA-019-0000.csv": "IPN,Qty\nASY-001-0000,1\nRES-001-1002,2\n",
+	})
+	chdir(t, dir)
+
+	exp := "BOM cycle: ASY-001-0000 -> PCA-019-0000 -> ASY-001-0000"
+
+	b := bom{}
+	err := b.processOurIPN("PCA-019-0000", 1, []ipn{"ASY-001-0000"})
+	if err == nil || !strings.Contains(err.Error(), exp) {
+		t.Errorf("processOurIPN() error = %v, want %v", err, exp)
+	}
+
+	top, err := loadSubBom("ASY-001-0000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = buildBomTree("ASY-001-0000", top)
+	if err == nil || !strings.Contains(err.Error(), exp) {
+		t.Errorf("buildBomTree() error = %v, want %v", err, exp)
+	}
+
+	idx, err := loadBomIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = idx.whereUsed("RES-001-1002")
+	if err == nil || !strings.Contains(err.Error(), "BOM cycle") {
+		t.Errorf("whereUsed() error = %v, want a BOM cycle", err)
+	}
+}
+
+func TestBomMaxDepth(t *testing.T) {
+	defer func() { maxBomDepth = 0 }()
+
+	path := []ipn{"ASY-001-0000", "ASY-002-0000"}
+
+	maxBomDepth = 3
+	if _, err := expandPath(path, "PCA-019-0000"); err != nil {
+		t.Errorf("expandPath() unexpected error: %v", err)
+	}
+
+	maxBomDepth = 2
+	_, err := expandPath(path, "PCA-019-0000")
+	exp := "BOM is more than 2 levels deep: ASY-001-0000 -> ASY-002-0000 -> PCA-019-0000"
+	if err == nil || err.Error() != exp {
+		t.Errorf("expandPath() error = %v, want %v", err, exp)
+	}
+}
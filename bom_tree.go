@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// bomTreeLine is one node of an indented (multi-level) BOM. Qty is the
+// quantity used in one Parent, and ExtQty the quantity used in one top level
+// assembly.
+type bomTreeLine struct {
+	Level        int     `csv:"Level"`
+	IPN          ipn     `csv:"IPN"`
+	Parent       ipn     `csv:"Parent"`
+	Qty          float64 `csv:"Qty"`
+	ExtQty       float64 `csv:"Ext Qty"`
+	Ref          string  `csv:"Ref"`
+	Description  string  `csv:"Description"`
+	Manufacturer string  `csv:"Manufacturer"`
+	MPN          string  `csv:"MPN"`
+}
+
+type bomTree []*bomTreeLine
+
+// buildBomTree returns the indented BOM of assembly pn, whose own BOM is b.
+// The BOMs of PCA/ASY sub-assemblies are loaded from their release packages,
+// the same as for the -all.csv purchase BOM.
+func buildBomTree(pn ipn, b bom) (bomTree, error) {
+	t := bomTree{{Level: 0, IPN: pn, Qty: 1, ExtQty: 1}}
+	err := t.add([]ipn{pn}, b, 1)
+	return t, err
+}
+
+// add adds the lines of BOM b to the tree. path is the chain of assemblies
+// from the top level down to the one b belongs to.
+func (t *bomTree) add(path []ipn, b bom, parentQty float64) error {
+	parent := path[len(path)-1]
+
+	for _, l := range b {
+		n := &bomTreeLine{
+			Level:        len(path),
+			IPN:          l.IPN,
+			Parent:       parent,
+			Qty:          l.Qty,
+			ExtQty:       l.Qty * parentQty,
+			Ref:          l.Ref,
+			Description:  l.Description,
+			Manufacturer: l.Manufacturer,
+			MPN:          l.MPN,
+		}
+		*t = append(*t, n)
+
+		isSub, _ := l.IPN.hasBOM()
+		if !isSub {
+			continue
+		}
+
+		subPath, err := expandPath(path, l.IPN)
+		if err != nil {
+			return err
+		}
+
+		subBom, err := loadSubBom(l.IPN)
+		if err != nil {
+			return err
+		}
+
+		err = t.add(subPath, subBom, n.ExtQty)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// markdown renders the tree as a Markdown table. Levels are shown with the
+// usual indented BOM notation: .1 for lines of the top level assembly, ..2 for
+// lines of its sub-assemblies, and so on.
+func (t bomTree) markdown() string {
+	var out strings.Builder
+
+	if len(t) > 0 {
+		fmt.Fprintf(&out, "# %v indented BOM\n\n", t[0].IPN)
+	}
+
+	out.WriteString("| Level | IPN | Parent | Qty | Ext Qty | Ref | Description | Manufacturer | MPN |\n")
+	out.WriteString("| ----- | --- | ------ | --- | ------- | --- | ----------- | ------------ | --- |\n")
+
+	for _, l := range t {
+		fmt.Fprintf(&out, "| %v | %v | %v | %v | %v | %v | %v | %v | %v |\n",
+			strings.Repeat(".", l.Level)+fmt.Sprint(l.Level),
+			l.IPN,
+			l.Parent,
+			l.Qty,
+			l.ExtQty,
+			markdownEscape(l.Ref),
+			markdownEscape(l.Description),
+			markdownEscape(l.Manufacturer),
+			markdownEscape(l.MPN))
+	}
+
+	return out.String()
+}
+
+// markdownEscape keeps a value from breaking a Markdown table cell
+func markdownEscape(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+// save writes the tree as <name>.csv and <name>.md
+func (t bomTree) save(pathNoExt string) error {
+	err := saveCSV(pathNoExt+".csv", t)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(pathNoExt+".md", []byte(t.markdown()), 0644)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v2"
+)
+
+// nextVariation returns the variation after v, with the same width. Only
+// variations that are numbers can be incremented; the others code a value.
+func nextVariation(v string) (string, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("variation %v is not a number", v)
+	}
+	next := fmt.Sprintf("%0*v", len(v), n+1)
+	if len(next) > len(v) {
+		return "", fmt.Errorf("variation %v is the last one", v)
+	}
+	return next, nil
+}
+
+// findReleaseDirs returns the release dirs below the working directory of the
+// variations of base (CCC-NNN), by IPN
+func findReleaseDirs(base string) (map[ipn]string, error) {
+	ret := map[ipn]string{}
+	err := fs.WalkDir(os.DirFS("./"), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != "." && strings.HasPrefix(d.Name(), ".") {
+			return fs.SkipDir
+		}
+		pn, err := newIpn(d.Name())
+		if err == nil && pn.base() == base {
+			ret[pn] = path
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// loadSourceBom loads the source BOM of a release of variation v, either a CSV
+// file, the KiCad design of its release script, or the items of its release
+// script, and applies the release script, as a release does. It returns nil if
+// the release has no BOM.
+func loadSourceBom(bomPath, ymlPath, v string) (bom, error) {
+	rs := relScript{}
+	if ymlPath != "" {
+		ymlBytes, err := os.ReadFile(ymlPath)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading yml file: %v", err)
+		}
+		err = yaml.Unmarshal(ymlBytes, &rs)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing yml: %v", err)
+		}
+		rs, _, err = rs.forVariation(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b := bom{}
+	switch {
+	case len(rs.Items) > 0:
+		var err error
+		b, err = rs.itemsBom(nil)
+		if err != nil {
+			return nil, err
+		}
+	case rs.Kicad != "":
+		var err error
+		b, err = loadKiCadBom(filepath.Join(filepath.Dir(ymlPath), rs.Kicad), func(string) {})
+		if err != nil {
+			return nil, err
+		}
+	case bomPath != "":
+		err := loadCSV(bomPath, &b)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+
+	return rs.processBom(b, func(string) {})
+}
+
+// bomItems returns the IPN, quantity, and references of the lines of a BOM,
+// without the partmaster information a release adds
+func bomItems(b bom) bom {
+	ret := bom{}
+	for _, l := range b.withoutAlternates() {
+		ret = append(ret, &bomLine{IPN: l.IPN, Qty: l.Qty, Ref: l.Ref})
+	}
+	return ret
+}
+
+// bumpPart starts the next variation of a part we make. arg is the part's IPN
+// or CCC-NNN. The latest variation is the highest one released, or listed in
+// the partmaster. The new variation is added to the partmaster file of the
+// latest one, with its fields copied, and gets a section in the CHANGELOG.md
+// of the source dir. It fails if the part has a BOM that is unchanged since
+// the latest release. It returns the new IPN and the source dir.
+func bumpPart(arg, pmDir, date string, logMsg func(string)) (ipn, string, error) {
+	base := arg
+	if pn, err := newIpn(arg); err == nil {
+		base = pn.base()
+	}
+
+	// the variation does not matter to find the category
+	groups := reSourceBase.FindStringSubmatch(base)
+	if len(groups) < 4 || groups[3] != "" {
+		return "", "", fmt.Errorf("%v is neither an IPN nor CCC-NNN", arg)
+	}
+	if !lo.Contains(ourIPNs, groups[1]) {
+		return "", "", fmt.Errorf("%v is not a part we make, only those have variations to bump", arg)
+	}
+
+	released, err := findReleaseDirs(base)
+	if err != nil {
+		return "", "", err
+	}
+
+	latestRelease := ipn("")
+	for pn := range released {
+		latestRelease = max(latestRelease, pn)
+	}
+	latest := latestRelease
+
+	// the partmaster row of the latest variation listed, to copy
+	var pmFile *CSVFile
+	var pmRow []string
+	pmPn := ipn("")
+	if pmDir != "" {
+		c, err := loadAllCSVFiles(pmDir)
+		if err != nil {
+			return "", "", fmt.Errorf("Error loading partmaster: %v", err)
+		}
+		for _, f := range c.Files {
+			idx := findHeaderIndex(f.Headers, "IPN")
+			if idx < 0 {
+				continue
+			}
+			for _, row := range f.Rows {
+				if idx >= len(row) {
+					continue
+				}
+				pn, err := newIpn(strings.TrimSpace(row[idx]))
+				if err != nil || pn.base() != base {
+					continue
+				}
+				if pn > pmPn {
+					pmFile, pmRow, pmPn = f, row, pn
+				}
+				latest = max(latest, pn)
+			}
+		}
+	}
+
+	if latest == "" {
+		return "", "", fmt.Errorf("%v has no release and is not in the partmaster", base)
+	}
+
+	_, _, v, _ := latest.parse()
+	nextV, err := nextVariation(v)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot bump %v: %v", latest, err)
+	}
+	newPn, err := newIpn(base + "-" + nextV)
+	if err != nil {
+		return "", "", err
+	}
+
+	bomPath, ymlPath, err := findReleaseSource(newPn.String())
+	if err != nil {
+		return "", "", fmt.Errorf("no source for %v: %v", newPn, err)
+	}
+	srcDir := filepath.Dir(bomPath)
+	if bomPath == "" {
+		srcDir = filepath.Dir(ymlPath)
+	}
+
+	// a new variation is for a changed BOM. A BOM generated by a hook at
+	// release time cannot be compared.
+	srcBom, err := loadSourceBom(bomPath, ymlPath, nextV)
+	if err != nil {
+		return "", "", fmt.Errorf("Error loading BOM: %v", err)
+	}
+	relBomPath := ""
+	if latestRelease != "" && srcBom != nil {
+		relBomPath = filepath.Join(released[latestRelease], latestRelease.String()+".csv")
+	}
+	if e, _ := exists(relBomPath); e && relBomPath != "" {
+		relBom := bom{}
+		err := loadCSV(relBomPath, &relBom)
+		if err != nil {
+			return "", "", err
+		}
+		d := diffBoms(latestRelease.String(), bomItems(relBom), "source", bomItems(srcBom))
+		if len(d.Lines) == 0 && len(d.Moves) == 0 {
+			return "", "", fmt.Errorf("the BOM of %v is unchanged since release %v", base, latestRelease)
+		}
+	}
+
+	// the section goes first: adding it again is a no-op, so if saving the
+	// partmaster fails, the bump can simply be run again
+	err = addChangelogSection(srcDir, newPn.String(), date)
+	if err != nil {
+		return "", "", fmt.Errorf("Error adding CHANGELOG.md section: %v", err)
+	}
+	logMsg(fmt.Sprintf("%v section added to %v", newPn, filepath.Join(srcDir, "CHANGELOG.md")))
+
+	if pmRow != nil {
+		idx := findHeaderIndex(pmFile.Headers, "IPN")
+		row := append([]string{}, pmRow...)
+		row[idx] = newPn.String()
+		pmFile.Rows = append(pmFile.Rows, row)
+		sortRowsByIPN(pmFile.Rows, idx)
+		err := saveCSVRaw(pmFile)
+		if err != nil {
+			return "", "", fmt.Errorf("Error saving %v: %v", pmFile.Path, err)
+		}
+		logMsg(fmt.Sprintf("%v added to %v, copied from %v", newPn, pmFile.Path, pmPn))
+	} else {
+		logMsg(fmt.Sprintf("%v is not in the partmaster, add %v by hand if needed", base, newPn))
+	}
+
+	return newPn, srcDir, nil
+}
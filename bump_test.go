@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNextVariation(t *testing.T) {
+	tests := []struct {
+		v, want string
+	}{
+		{"0000", "0001"},
+		{"0009", "0010"},
+		{"01", "02"},
+		{"9999", ""},
+		{"02V5", ""},
+	}
+
+	for _, test := range tests {
+		got, err := nextVariation(test.v)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("nextVariation(%v) = %v, want an error", test.v, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("nextVariation(%v) = %v, %v, want %v", test.v, got, err, test.want)
+		}
+	}
+}
+
+func TestBumpPart(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+
+	release(t, "PCB-019-0001", pmDir)
+	release(t, "PCA-019-0000", pmDir)
+
+	bump := func(arg string) (ipn, error) {
+		pn, _, err := bumpPart(arg, pmDir, "2026-02-01", func(string) {})
+		return pn, err
+	}
+
+	if _, err := bump("PCA-019"); err == nil || !strings.Contains(err.Error(), "unchanged") {
+		t.Errorf("bump of an unchanged BOM: %v", err)
+	}
+	if _, err := bump("RES-001-1002"); err == nil {
+		t.Error("bump of a purchased part did not fail")
+	}
+
+	writeTree(t, ".", map[string]string{
+		"elec/PCA-019.csv": `Ref,Qty,Value,Cmp name,Footprint,Description,Vendor,IPN,Datasheet
+R1 R2 R3,3,10k,R,,,,RES-001-1002,
+PCB1,1,,PCB,,,,PCB-019-0001,
+`,
+	})
+
+	// a bump that cannot add its CHANGELOG.md section leaves the partmaster
+	// alone
+	changelogPath := filepath.Join("elec", "CHANGELOG.md")
+	changelog, err := os.ReadFile(changelogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(changelogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(changelogPath, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bump("PCB-019"); err == nil {
+		t.Error("bump without a writable CHANGELOG.md did not fail")
+	}
+	data, err := os.ReadFile(filepath.Join(pmDir, "pcb.csv"))
+	if err != nil || string(data) != releaseTree["pm/pcb.csv"] {
+		t.Errorf("failed bump changed pcb.csv:\n%s", data)
+	}
+	err = os.Remove(changelogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, ".", map[string]string{
+		changelogPath: string(changelog),
+	})
+
+	tests := []struct {
+		arg, want string
+	}{
+		{"PCA-019-0000", "PCA-019-0001"},
+		// boards have no BOM to compare
+		{"PCB-019", "PCB-019-0002"},
+		// not released yet, the partmaster has the latest variation
+		{"ASY-001", "ASY-001-0001"},
+	}
+
+	for _, test := range tests {
+		pn, err := bump(test.arg)
+		if err != nil {
+			t.Fatalf("bump %v: %v", test.arg, err)
+		}
+		if pn.String() != test.want {
+			t.Errorf("bump %v = %v, want %v", test.arg, pn, test.want)
+		}
+	}
+
+	data, err = os.ReadFile(filepath.Join(pmDir, "pca.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(data), "PCA-019-0000,PCB assembly,mycompany,,Y\nPCA-019-0001,PCB assembly,mycompany,,Y\n") {
+		t.Errorf("pca.csv:\n%s", data)
+	}
+
+	data, err = os.ReadFile(filepath.Join("elec", "CHANGELOG.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "# Changelog\n\n## [PCB-019-0002] - 2026-02-01\n\n## [PCA-019-0001] - 2026-02-01\n\n## [PCA-019-0000]") {
+		t.Errorf("CHANGELOG.md:\n%s", data)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// changelogSection is one release section of a keep-a-changelog file, such as
+//
+//	## [ASY-001-0003] - 2024-03-02
+//
+//	- added mounting bracket
+type changelogSection struct {
+	Version string
+	Heading string
+	Body    string
+}
+
+// reChangelogHeading matches a release heading and captures the version. Both
+// "## [ASY-001-0003] - date" and the linked "## [[0.8.12] - date](url)" forms
+// are accepted.
+var reChangelogHeading = regexp.MustCompile(`^##\s+\[*([^\]\[\s]+)\]?`)
+
+func parseChangelog(data string) []changelogSection {
+	ret := []changelogSection{}
+	var cur *changelogSection
+	var body []string
+
+	flush := func() {
+		if cur != nil {
+			cur.Body = strings.TrimSpace(strings.Join(body, "\n"))
+			ret = append(ret, *cur)
+		}
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(line, "## ") {
+			flush()
+			cur = &changelogSection{Heading: strings.TrimSpace(line)}
+			body = nil
+			if groups := reChangelogHeading.FindStringSubmatch(line); groups != nil {
+				cur.Version = groups[1]
+			}
+			continue
+		}
+		// a top level heading ends the last section
+		if strings.HasPrefix(line, "# ") {
+			flush()
+			cur = nil
+			continue
+		}
+		body = append(body, line)
+	}
+	flush()
+
+	return ret
+}
+
+// changelogShared reports whether the CHANGELOG.md of srcDir serves more than
+// one part: the dir holds the sources of several parts, or the changelog names
+// several parts. Its entries must then be headed by the full IPN, as a bare
+// variation could be of any of them.
+func changelogShared(srcDir string, sections []changelogSection) (bool, error) {
+	bases := map[string]bool{}
+	for _, s := range sections {
+		if _, err := newIpn(s.Version); err == nil {
+			bases[ipn(s.Version).base()] = true
+		}
+	}
+
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".csv" && ext != ".yml") {
+			continue
+		}
+		groups := reSourceBase.FindStringSubmatch(strings.TrimSuffix(e.Name(), ext))
+		if len(groups) >= 3 {
+			bases[groups[1]+"-"+groups[2]] = true
+		}
+	}
+
+	return len(bases) > 1, nil
+}
+
+// releaseChangelogSection returns the entry for a release in the CHANGELOG.md
+// of its source dir. The entry is a section headed by the full IPN, such as
+// "## [ASY-001-0003]", or by just its variation, "## [0003]", if the changelog
+// is not shared with other parts. nil is returned if there is no changelog or
+// no entry with any text in it.
+func releaseChangelogSection(srcDir, relPn string) (*changelogSection, error) {
+	data, err := os.ReadFile(filepath.Join(srcDir, "CHANGELOG.md"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, v, err := ipn(relPn).parse()
+	if err != nil {
+		return nil, err
+	}
+
+	sections := parseChangelog(string(data))
+	shared, err := changelogShared(srcDir, sections)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range sections {
+		if (s.Version == relPn || (!shared && s.Version == v)) && s.Body != "" {
+			return &s, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// addChangelogSection adds an empty section for a release to the top of the
+// CHANGELOG.md in srcDir, creating the file if needed, so it can be filled in
+// with an editor.
+func addChangelogSection(srcDir, relPn, date string) error {
+	path := filepath.Join(srcDir, "CHANGELOG.md")
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	for _, s := range parseChangelog(string(data)) {
+		if s.Version == relPn {
+			// already there, waiting to be filled in
+			return nil
+		}
+	}
+
+	section := fmt.Sprintf("## [%v] - %v\n\n", relPn, date)
+
+	content := string(data)
+	if content == "" {
+		content = "# Changelog\n\n" + section
+	} else {
+		// new sections go above the latest release
+		lines := strings.SplitAfter(content, "\n")
+		i := 0
+		for ; i < len(lines); i++ {
+			if strings.HasPrefix(lines[i], "## ") {
+				break
+			}
+		}
+		if i == len(lines) {
+			content = strings.TrimRight(content, "\n") + "\n\n" + section
+		} else {
+			content = strings.Join(lines[:i], "") + section + strings.Join(lines[i:], "")
+		}
+	}
+
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// writeReleaseNotes writes the changelog entry of a release to RELEASE_NOTES.md
+// in the release dir
+func writeReleaseNotes(releaseDir string, section *changelogSection) error {
+	notes := section.Heading + "\n\n" + section.Body + "\n"
+	return os.WriteFile(filepath.Join(releaseDir, "RELEASE_NOTES.md"), []byte(notes), 0644)
+}
+
+// subReleases adds the releases linked from releaseDir, and the releases
+// linked from those, to found, by IPN
+func subReleases(releaseDir string, found map[string]string) error {
+	entries, err := os.ReadDir(releaseDir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.Type()&fs.ModeSymlink == 0 {
+			continue
+		}
+		if _, err := newIpn(e.Name()); err != nil {
+			continue
+		}
+		if _, ok := found[e.Name()]; ok {
+			continue
+		}
+		dir := resolvePath(filepath.Join(releaseDir, e.Name()))
+		found[e.Name()] = dir
+		err := subReleases(dir, found)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// previousRelease returns the latest release dir in sourceDir of an earlier
+// variation of relPn, or "" if there is none
+func previousRelease(sourceDir, relPn string) string {
+	entries, err := os.ReadDir(sourceDir)
+	if err != nil {
+		return ""
+	}
+
+	base := ipn(relPn).base()
+	ret := ""
+	for _, e := range entries {
+		if !e.IsDir() || ipn(e.Name()).base() != base {
+			continue
+		}
+		if e.Name() < relPn && e.Name() > ret {
+			ret = e.Name()
+		}
+	}
+
+	return ret
+}
+
+// writeChangelogAll writes CHANGELOG-all.md to a release dir. It has the entry
+// of the release itself, followed by the entries of each sub-assembly version
+// that is new since the previous release, so one document describes everything
+// that changed in a build. It returns false if there are no sub-assemblies.
+func writeChangelogAll(relPn, sourceDir, releaseDir string, notes *changelogSection) (bool, error) {
+	cur := map[string]string{}
+	err := subReleases(releaseDir, cur)
+	if err != nil {
+		return false, err
+	}
+	if len(cur) <= 0 {
+		return false, nil
+	}
+
+	// variations of the sub-assemblies in the previous release, by base
+	prevVars := map[string]string{}
+	prev := previousRelease(sourceDir, relPn)
+	if prev != "" {
+		prevSubs := map[string]string{}
+		err := subReleases(filepath.Join(sourceDir, prev), prevSubs)
+		if err != nil {
+			return false, err
+		}
+		for pn := range prevSubs {
+			_, _, v, _ := ipn(pn).parse()
+			prevVars[ipn(pn).base()] = v
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "# Changes in %v\n\n", relPn)
+	if prev != "" {
+		fmt.Fprintf(&out, "Since %v.\n\n", prev)
+	}
+	fmt.Fprintf(&out, "## %v\n\n%v\n", relPn, notes.Body)
+
+	pns := []string{}
+	for pn := range cur {
+		pns = append(pns, pn)
+	}
+	sort.Strings(pns)
+
+	for _, pn := range pns {
+		base := ipn(pn).base()
+		_, _, v, _ := ipn(pn).parse()
+		prevV, inPrev := prevVars[base]
+		if inPrev && prevV == v {
+			continue
+		}
+
+		fmt.Fprintf(&out, "\n## %v\n\n", pn)
+		if inPrev {
+			fmt.Fprintf(&out, "Previously %v-%v.\n\n", base, prevV)
+		}
+
+		// the source dir of a sub-assembly holds its release dir
+		sections, err := changedSections(filepath.Dir(cur[pn]), base, prevV, v)
+		if err != nil {
+			return false, err
+		}
+		if len(sections) <= 0 {
+			out.WriteString("No CHANGELOG.md entry.\n")
+		}
+		for i, s := range sections {
+			if i > 0 {
+				out.WriteString("\n")
+			}
+			fmt.Fprintf(&out, "#%v\n\n%v\n", s.Heading, s.Body)
+		}
+	}
+
+	err = os.WriteFile(filepath.Join(releaseDir, "CHANGELOG-all.md"), []byte(out.String()), 0644)
+	return err == nil, err
+}
+
+// changedSections returns the sections of the CHANGELOG.md in srcDir for the
+// variations of base after prevV up to v. If prevV is "" or not before v, only
+// the sections for v are returned.
+func changedSections(srcDir, base, prevV, v string) ([]changelogSection, error) {
+	data, err := os.ReadFile(filepath.Join(srcDir, "CHANGELOG.md"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if prevV >= v {
+		prevV = ""
+	}
+
+	sections := parseChangelog(string(data))
+	shared, err := changelogShared(srcDir, sections)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []changelogSection{}
+	for _, s := range sections {
+		// a bare variation is only of base if the changelog is not shared.
+		// A shared changelog must name variations in full.
+		pn := ipn(s.Version)
+		if _, err := newIpn(s.Version); err != nil && !shared {
+			pn = ipn(base + "-" + s.Version)
+		}
+		if pn.base() != base {
+			continue
+		}
+		_, _, sv, _ := pn.parse()
+		if sv == v || (prevV != "" && sv > prevV && sv < v) {
+			ret = append(ret, s)
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseChangelog(t *testing.T) {
+	data := `# Changelog
+
+Intro text.
+
+## [Unreleased]
+
+## [ASY-001-0003] - 2024-03-02
+
+- added mounting bracket
+
+## [[0.8.12] - 2026-03-02](https://example.com/v0.8.12)
+
+- fixed
+`
+
+	sections := parseChangelog(data)
+	if len(sections) != 3 {
+		t.Fatalf("got %v sections, want 3: %+v", len(sections), sections)
+	}
+
+	exp := []changelogSection{
+		{Version: "Unreleased", Heading: "## [Unreleased]", Body: ""},
+		{Version: "ASY-001-0003", Heading: "## [ASY-001-0003] - 2024-03-02", Body: "- added mounting bracket"},
+		{Version: "0.8.12", Heading: "## [[0.8.12] - 2026-03-02](https://example.com/v0.8.12)", Body: "- fixed"},
+	}
+	for i, s := range sections {
+		if s != exp[i] {
+			t.Errorf("section %v = %+v, want %+v", i, s, exp[i])
+		}
+	}
+}
+
+func TestReleaseChangelog(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+
+	relDir := release(t, "PCB-019-0001", pmDir)
+	notes, err := os.ReadFile(filepath.Join(relDir, "RELEASE_NOTES.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(notes) != "## [PCB-019-0001] - 2026-01-01\n\n- first board\n" {
+		t.Errorf("RELEASE_NOTES.md = %q", notes)
+	}
+
+	// a bare variation heading names the part only if the changelog is not
+	// shared with other parts
+	writeTree(t, ".", map[string]string{
+		"mech/ASY-002.csv":  "IPN,Qty\nSCR-002-0002,2\n",
+		"mech/CHANGELOG.md": "# Changelog\n\n## [0001]\n\n- bracket\n",
+	})
+	section, err := releaseChangelogSection("mech", "ASY-002-0001")
+	if err != nil || section == nil || section.Body != "- bracket" {
+		t.Errorf("releaseChangelogSection() = %v, %v, want the 0001 entry", section, err)
+	}
+	writeTree(t, ".", map[string]string{
+		"mech/ASY-003.csv": "IPN,Qty\nSCR-002-0002,1\n",
+	})
+	section, err = releaseChangelogSection("mech", "ASY-002-0001")
+	if err != nil || section != nil {
+		t.Errorf("shared changelog: releaseChangelogSection() = %v, %v, want no entry", section, err)
+	}
+
+	var relLog strings.Builder
+	_, err = processRelease("PCB-019-0002", &relLog, pmDir)
+	if err == nil || !strings.Contains(err.Error(), "has no entry for PCB-019-0002") {
+		t.Errorf("release without changelog entry: %v", err)
+	}
+	if e, _ := exists(filepath.Join("elec", "PCB-019-0002")); e {
+		t.Error("release dir created without changelog entry")
+	}
+
+	// an empty section does not count as an entry
+	err = addChangelogSection("elec", "PCB-019-0002", "2026-02-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = addChangelogSection("elec", "PCB-019-0002", "2026-02-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	section, err = releaseChangelogSection("elec", "PCB-019-0002")
+	if err != nil || section != nil {
+		t.Errorf("releaseChangelogSection() = %v, %v, want no entry", section, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join("elec", "CHANGELOG.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "# Changelog\n\n## [PCB-019-0002] - 2026-02-01\n\n## [PCA-019-0000]") {
+		t.Errorf("section not added once above the latest release:\n%s", data)
+	}
+
+	// a changelog is created if there is none
+	err = os.Remove("CHANGELOG.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = addChangelogSection(".", "ASY-002-0000", "2026-02-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile("CHANGELOG.md")
+	if err != nil || string(data) != "# Changelog\n\n## [ASY-002-0000] - 2026-02-01\n\n" {
+		t.Errorf("new CHANGELOG.md = %q, %v", data, err)
+	}
+
+	// a failed release writes no release notes
+	err = os.RemoveAll(relDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, ".", map[string]string{
+		"elec/PCB-019.yml": "required:\n  - gerbers.zip\n",
+	})
+	relLog.Reset()
+	_, err = processRelease("PCB-019-0001", &relLog, pmDir)
+	if err == nil {
+		t.Fatal("release without a required file did not fail")
+	}
+	if e, _ := exists(filepath.Join(relDir, "RELEASE_NOTES.md")); e {
+		t.Error("failed release wrote RELEASE_NOTES.md")
+	}
+}
+
+func TestChangelogAll(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+
+	release(t, "PCB-019-0001", pmDir)
+	release(t, "PCA-019-0000", pmDir)
+	asyDir := release(t, "ASY-001-0000", pmDir)
+
+	data, err := os.ReadFile(filepath.Join(asyDir, "CHANGELOG-all.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `# Changes in ASY-001-0000
+
+## ASY-001-0000
+
+- first product
+
+## PCA-019-0000
+
+### [PCA-019-0000] - 2026-01-02
+
+- first assembly
+
+## PCB-019-0001
+
+### [PCB-019-0001] - 2026-01-01
+
+- first board
+`
+	if string(data) != exp {
+		t.Errorf("CHANGELOG-all.md =\n%s\nwant\n%s", data, exp)
+	}
+
+	// a new product build with two new board assembly versions, the first of
+	// which was never built into a product
+	writeTree(t, ".", map[string]string{
+		"ASY-001.csv": "IPN,Qty\nPCA-019-0002,2\nSCR-002-0002,4\n",
+		"CHANGELOG.md": "# Changelog\n\n## [ASY-001-0001]\n\n- new board\n\n" +
+			"## [ASY-001-0000]\n\n- first product\n",
+		filepath.Join("elec", "CHANGELOG.md"): "# Changelog\n\n" +
+			"## [PCA-019-0002]\n\n- fix\n\n## [PCA-019-0001]\n\n- rework\n\n" +
+			"## [PCA-019-0000]\n\n- first assembly\n\n## [PCB-019-0001]\n\n- first board\n",
+	})
+	release(t, "PCA-019-0001", pmDir)
+	release(t, "PCA-019-0002", pmDir)
+	asyDir = release(t, "ASY-001-0001", pmDir)
+
+	data, err = os.ReadFile(filepath.Join(asyDir, "CHANGELOG-all.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp = `# Changes in ASY-001-0001
+
+Since ASY-001-0000.
+
+## ASY-001-0001
+
+- new board
+
+## PCA-019-0002
+
+Previously PCA-019-0000.
+
+### [PCA-019-0002]
+
+- fix
+
+### [PCA-019-0001]
+
+- rework
+`
+	if string(data) != exp {
+		t.Errorf("CHANGELOG-all.md =\n%s\nwant\n%s", data, exp)
+	}
+}
+
+func TestChangedSections(t *testing.T) {
+	initCSV()
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"ASY-002.csv": "IPN,Qty\n",
+		"CHANGELOG.md": "# Changelog\n\n## [0003]\n\n- third\n\n" +
+			"## [0002]\n\n- second\n\n## [0001]\n\n- first\n",
+	})
+
+	versions := func() []string {
+		t.Helper()
+		sections, err := changedSections(dir, "ASY-002", "0001", "0003")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := []string{}
+		for _, s := range sections {
+			ret = append(ret, s.Version)
+		}
+		return ret
+	}
+
+	// the variations between the releases, named by variation only
+	if v := versions(); !reflect.DeepEqual(v, []string{"0003", "0002"}) {
+		t.Errorf("changed sections = %v, want [0003 0002]", v)
+	}
+
+	// a changelog shared by several parts must name them in full
+	writeTree(t, dir, map[string]string{"ASY-012.csv": "IPN,Qty\n"})
+	if v := versions(); len(v) != 0 {
+		t.Errorf("changed sections of a shared changelog = %v, want none", v)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -72,9 +73,41 @@ func (h HTTPConfig) FieldsForCategory(category string) FieldConfig {
 	return merged
 }
 
+// ReleaseConfig configures how releases are processed
+type ReleaseConfig struct {
+	// MaxDepth limits how many levels of sub-assemblies a BOM may have. 0
+	// means no limit.
+	MaxDepth int `yaml:"maxDepth"`
+	// Alternates lists the alternate sources of parts in release BOMs, as
+	// "columns" or "rows". They are not listed if empty.
+	Alternates string `yaml:"alternates"`
+	// Strict makes a release fail if a BOM has parts that are not in the
+	// partmaster, purchased parts without an MPN, or parts not checked
+	Strict bool `yaml:"strict"`
+}
+
+// IPNConfig configures the IPN scheme. Settings that are not given keep their
+// defaults.
+type IPNConfig struct {
+	// Category, Number and Variation are regular expressions for the segments
+	// of an IPN, which are separated by -: CCC-NNN-VVVV
+	Category  string `yaml:"category"`
+	Number    string `yaml:"number"`
+	Variation string `yaml:"variation"`
+	// Ours are the categories of parts we make and release, and BOMs those of
+	// them that are assemblies
+	Ours []string `yaml:"ours"`
+	BOMs []string `yaml:"boms"`
+	// VariationPrefix is the number of characters of the variation in source
+	// file names such as CCC-NNN-VV.csv
+	VariationPrefix *int `yaml:"variationPrefix"`
+}
+
 type Config struct {
-	PMDir string     `yaml:"pmDir"`
-	HTTP  HTTPConfig `yaml:"http"`
+	PMDir   string        `yaml:"pmDir"`
+	HTTP    HTTPConfig    `yaml:"http"`
+	Release ReleaseConfig `yaml:"release"`
+	IPN     IPNConfig     `yaml:"ipn"`
 }
 
 var configNames = []string{
@@ -136,6 +169,20 @@ func loadConfig() (*Config, error) {
 		return nil, err
 	}
 
+	err = setIpnScheme(config.IPN)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", configPath, err)
+	}
+
+	maxBomDepth = config.Release.MaxDepth
+
+	err = checkAlternatesLayout(config.Release.Alternates)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", configPath, err)
+	}
+	alternatesLayout = config.Release.Alternates
+	strictRelease = config.Release.Strict
+
 	// Resolve relative pmDir against the config file's directory
 	if config.PMDir != "" && !filepath.IsAbs(config.PMDir) {
 		config.PMDir = filepath.Join(filepath.Dir(configPath), config.PMDir)
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -18,11 +19,21 @@ type CSVFile struct {
 	Headers []string
 	Rows    [][]string
 	UseCRLF bool
+	// Malformed lists the rows that could not be read and were skipped
+	Malformed []CSVRowError
+}
+
+// CSVRowError is a row of a CSV file that could not be read
+type CSVRowError struct {
+	Line int
+	Err  error
 }
 
 // CSVFileCollection represents all CSV files loaded from a directory
 type CSVFileCollection struct {
 	Files []*CSVFile
+	// LoadErrors has the files that could not be loaded, by path
+	LoadErrors map[string]error
 }
 
 // loadCSVRaw loads a CSV file without struct mapping, preserving all columns
@@ -62,7 +73,7 @@ func loadCSVRaw(filePath string) (*CSVFile, error) {
 
 	// Read all rows
 	var rows [][]string
-	lineNum := 1 // Start at 1 since headers are line 0
+	var malformed []CSVRowError
 	for {
 		row, err := reader.Read()
 		if err == io.EOF {
@@ -70,27 +81,34 @@ func loadCSVRaw(filePath string) (*CSVFile, error) {
 		}
 		if err != nil {
 			// Skip malformed rows and continue
-			fmt.Printf("Warning: error reading row %d from %s: %v\n", lineNum, filePath, err)
-			lineNum++
+			line, _ := reader.FieldPos(0)
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				line = parseErr.StartLine
+				err = parseErr.Err
+			}
+			fmt.Fprintf(os.Stderr, "Warning: error reading line %d from %s: %v\n", line, filePath, err)
+			malformed = append(malformed, CSVRowError{Line: line, Err: err})
 			continue
 		}
 		rows = append(rows, row)
-		lineNum++
 	}
 
 	return &CSVFile{
-		Name:    filepath.Base(filePath),
-		Path:    filePath,
-		Headers: headers,
-		Rows:    rows,
-		UseCRLF: useCRLF,
+		Name:      filepath.Base(filePath),
+		Path:      filePath,
+		Headers:   headers,
+		Rows:      rows,
+		UseCRLF:   useCRLF,
+		Malformed: malformed,
 	}, nil
 }
 
 // loadAllCSVFiles loads all CSV files from a directory
 func loadAllCSVFiles(dir string) (*CSVFileCollection, error) {
 	collection := &CSVFileCollection{
-		Files: []*CSVFile{},
+		Files:      []*CSVFile{},
+		LoadErrors: map[string]error{},
 	}
 
 	files, err := filepath.Glob(filepath.Join(dir, "*.csv"))
@@ -102,7 +120,8 @@ func loadAllCSVFiles(dir string) (*CSVFileCollection, error) {
 		csvFile, err := loadCSVRaw(filePath)
 		if err != nil {
 			// Log error but continue loading other files
-			fmt.Printf("Warning: error loading CSV file %s: %v\n", filePath, err)
+			fmt.Fprintf(os.Stderr, "Warning: error loading CSV file %s: %v\n", filePath, err)
+			collection.LoadErrors[filePath] = err
 			continue
 		}
 		collection.Files = append(collection.Files, csvFile)
@@ -274,33 +293,23 @@ func sortRowsByIPN(rows [][]string, ipnColIdx int) {
 	})
 }
 
-// nextAvailableIPN scans rows to determine the category (CCC) and the maximum
-// NNN value, then returns CCC-(NNN+1)-0001 as the next available IPN string.
-func nextAvailableIPN(rows [][]string, ipnColIdx int) (string, error) {
+// nextAvailableIPN takes the category (CCC) from the first IPN in rows, and
+// returns the next IPN in that category not used in rows or by a, as
+// CCC-NNN-0001. a may be nil, to only consider rows.
+func nextAvailableIPN(rows [][]string, ipnColIdx int, a *ipnAllocator) (string, error) {
 	if ipnColIdx < 0 {
 		return "", fmt.Errorf("no IPN column")
 	}
 
 	category := ""
-	maxN := 0
-	nDigits := 3
-
 	for _, row := range rows {
 		if ipnColIdx >= len(row) {
 			continue
 		}
-		parsed, err := newIpn(row[ipnColIdx])
-		if err != nil {
-			continue
-		}
-		c, _ := parsed.c()
-		n, _ := parsed.n()
-		if category == "" {
+		c, err := ipn(row[ipnColIdx]).c()
+		if err == nil {
 			category = c
-			nDigits = parsed.nWidth()
-		}
-		if n > maxN {
-			maxN = n
+			break
 		}
 	}
 
@@ -308,17 +317,15 @@ func nextAvailableIPN(rows [][]string, ipnColIdx int) (string, error) {
 		return "", fmt.Errorf("no valid IPNs found to determine category")
 	}
 
-	newN := maxN + 1
-	// If existing IPNs use 4-digit N, preserve that format
-	if nDigits >= 4 || newN > 999 {
-		nDigits = 4
+	if a == nil {
+		a = newIpnAllocatorEmpty()
 	}
+	a.addRows(rows, ipnColIdx, "")
 
-	nFmt := fmt.Sprintf("%%0%dv", nDigits)
-	newIPNStr := fmt.Sprintf("%v-"+nFmt+"-%04v", category, newN, 1)
-	newIPN, err := newIpn(newIPNStr)
+	newIPN, err := a.next(category, "")
 	if err != nil {
 		return "", fmt.Errorf("error creating new IPN: %v", err)
 	}
-	return string(newIPN), nil
+
+	return newIPN.String(), nil
 }
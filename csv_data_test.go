@@ -48,7 +48,7 @@ func TestNextAvailableIPN(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			got, err := nextAvailableIPN(test.rows, 0)
+			got, err := nextAvailableIPN(test.rows, 0, nil)
 			if err != nil {
 				t.Fatalf("nextAvailableIPN() error: %v", err)
 			}
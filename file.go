@@ -2,10 +2,13 @@ package main
 
 import (
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/gocarina/gocsv"
 )
@@ -31,11 +34,27 @@ func saveCSV(filename string, data any) error {
 	return gocsv.MarshalFile(data, file)
 }
 
+// writeCSVRecords writes records to a CSV file
+func writeCSVRecords(filename string, records [][]string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return csv.NewWriter(file).WriteAll(records)
+}
+
 // findDir recursively searches the directory tree for a directory name. This skips soft links.
 func findDir(name string) (string, error) {
 	retPath := ""
 	// WalkDir does not follown symbolic links
 	err := fs.WalkDir(os.DirFS("./"), ".", func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path != "." {
+			// removed while walking, such as by a release running
+			// concurrently
+			return nil
+		}
 		if err != nil {
 			return err
 		}
@@ -64,6 +83,11 @@ func findFile(name string) (string, error) {
 	retPath := ""
 	// WalkDir does not follown symbolic links
 	err := fs.WalkDir(os.DirFS("./"), ".", func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path != "." {
+			// removed while walking, such as by a release running
+			// concurrently
+			return nil
+		}
 		if err != nil {
 			return err
 		}
@@ -87,6 +111,30 @@ func findFile(name string) (string, error) {
 	return retPath, nil
 }
 
+// findBomFiles recursively searches the directory tree for BOMs: source BOMs
+// (CCC-NNN.csv, CCC-NNN-VV.csv) and released BOMs (CCC-NNN-VVVV.csv). The map
+// is keyed by file name without the extension. Generated roll-ups such as
+// -all.csv are not included.
+func findBomFiles() (map[string]string, error) {
+	ret := map[string]string{}
+	// WalkDir does not follown symbolic links
+	err := fs.WalkDir(os.DirFS("./"), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(d.Name()) != ".csv" {
+			return nil
+		}
+		name := strings.TrimSuffix(d.Name(), ".csv")
+		if reIpn.MatchString(name) || reSourceBase.MatchString(name) {
+			ret[name] = path
+		}
+		return nil
+	})
+
+	return ret, err
+}
+
 func initCSV() {
 	gocsv.SetCSVReader(func(in io.Reader) gocsv.CSVReader {
 		r := csv.NewReader(in)
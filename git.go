@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/otiai10/copy"
+)
+
+// git runs a git command in dir and returns its trimmed stdout
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("git %v: %v: %v", strings.Join(args, " "), err,
+			strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// gitRoot returns the top level directory of the repo dir is in
+func gitRoot(dir string) (string, error) {
+	return git(dir, "rev-parse", "--show-toplevel")
+}
+
+// gitIsDirty reports whether the repo dir is in has uncommitted changes,
+// including untracked files. Release output, which every release leaves
+// behind, is not counted as a change.
+func gitIsDirty(dir string) (bool, error) {
+	out, err := git(dir, "status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if len(line) < 4 {
+			continue
+		}
+		// a rename is listed as "from -> to"
+		for _, path := range strings.Split(line[3:], " -> ") {
+			if !isReleaseOutput(strings.Trim(path, `"`)) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// isReleaseOutput reports whether path is written by releases: in a release
+// dir, a release archive, or a release log.
+func isReleaseOutput(path string) bool {
+	parts := strings.Split(strings.TrimSuffix(filepath.ToSlash(path), "/"), "/")
+	for _, p := range parts[:len(parts)-1] {
+		if reIpn.MatchString(p) {
+			return true
+		}
+	}
+
+	name := parts[len(parts)-1]
+	switch {
+	case reIpn.MatchString(name):
+		return true
+	case strings.HasSuffix(name, ".log"):
+		return reSourceBase.MatchString(strings.TrimSuffix(name, ".log"))
+	}
+	for _, ext := range []string{"." + archiveZip, "." + archiveTarGz} {
+		if strings.HasSuffix(name, ext) {
+			return reIpn.MatchString(strings.TrimSuffix(name, ext))
+		}
+	}
+	return false
+}
+
+// gitSourceCommit returns the commit checked out in dir, with a -dirty suffix
+// if there are uncommitted changes.
+func gitSourceCommit(dir string) (string, error) {
+	commit, err := git(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	dirty, err := gitIsDirty(dir)
+	if err != nil {
+		return "", err
+	}
+	if dirty {
+		commit += "-dirty"
+	}
+
+	return commit, nil
+}
+
+// processReleaseAtRev processes a release from the source tree at git
+// revision rev (a tag, branch or commit) instead of from the working tree.
+// The tree at rev is checked out in a temporary worktree, the release is
+// processed there, and the release directory is copied back to the same
+// location in the working tree. A pmDir inside the repo is also read at rev.
+func processReleaseAtRev(relPn string, relLog *strings.Builder, pmDir, rev string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	root, err := gitRoot(wd)
+	if err != nil {
+		return "", fmt.Errorf("Error, -rev requires a git repo: %v", err)
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+
+	wdRel, err := filepath.Rel(root, resolvePath(wd))
+	if err != nil {
+		return "", err
+	}
+
+	commit, err := git(root, "rev-parse", "--verify", rev+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("Error finding revision %v: %v", rev, err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "gitplm-release-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	worktree := filepath.Join(tmpDir, "src")
+	_, err = git(root, "worktree", "add", "--detach", worktree, commit)
+	if err != nil {
+		return "", fmt.Errorf("Error checking out %v: %v", rev, err)
+	}
+	defer func() {
+		_, _ = git(root, "worktree", "remove", "--force", worktree)
+	}()
+
+	if pmDir != "" {
+		pmAbs, err := filepath.Abs(pmDir)
+		if err != nil {
+			return "", err
+		}
+		pmRel, err := filepath.Rel(root, resolvePath(pmAbs))
+		if err == nil && !strings.HasPrefix(pmRel, "..") {
+			// relative to the dir the release runs in, so the release
+			// log and manifest do not record the temporary worktree
+			pmDir, err = filepath.Rel(wdRel, pmRel)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	err = os.Chdir(filepath.Join(worktree, wdRel))
+	if err != nil {
+		return "", fmt.Errorf("Error, %v does not exist at %v: %v", wdRel, rev, err)
+	}
+	defer func() {
+		_ = os.Chdir(wd)
+	}()
+
+	relLog.WriteString(fmt.Sprintf("Releasing from revision %v\n", rev))
+
+	srcDir, relErr := processRelease(relPn, relLog, pmDir)
+	if srcDir == "" {
+		return "", relErr
+	}
+
+	// a failed release or a dry run leaves the working tree as it was
+	if relErr != nil || dryRunRelease {
+		return srcDir, relErr
+	}
+
+	// copy the release dir back into the working tree, keeping the links
+	// to sub-assembly releases. A release dir already there is replaced, as
+	// its links cannot be copied over.
+	relDir := filepath.Join(srcDir, relPn)
+	relDirExists, err := exists(relDir)
+	if err != nil || !relDirExists {
+		return srcDir, err
+	}
+
+	err = os.RemoveAll(filepath.Join(wd, relDir))
+	if err != nil {
+		return srcDir, fmt.Errorf("Error removing previous release: %v", err)
+	}
+
+	opts := copy.Options{
+		OnSymlink: func(src string) copy.SymlinkAction {
+			return copy.Shallow
+		},
+		OnDirExists: func(src, dest string) copy.DirExistsAction {
+			return copy.Replace
+		},
+	}
+	err = copy.Copy(relDir, filepath.Join(wd, relDir), opts)
+	if err != nil {
+		return srcDir, fmt.Errorf("Error copying release from worktree: %v", err)
+	}
+
+	if archivePath := releaseArchivePath(relDir); archivePath != "" {
+		err = copy.Copy(archivePath, filepath.Join(wd, archivePath))
+		if err != nil {
+			return srcDir, fmt.Errorf("Error copying archive from worktree: %v", err)
+		}
+	}
+
+	return srcDir, nil
+}
+
+// resolvePath returns path with symbolic links evaluated, or path itself if
+// that fails
+func resolvePath(path string) string {
+	ret, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+	return ret
+}
+
+// releaseMessage returns the commit and tag message for a release: the IPN,
+// followed by its entry in the source dir's CHANGELOG.md if there is one.
+func releaseMessage(relPn, srcDir string) string {
+	msg := "Release " + relPn
+
+	section, err := releaseChangelogSection(srcDir, relPn)
+	if err == nil && section != nil {
+		msg += "\n\n" + section.Body
+	}
+
+	return msg + "\n"
+}
+
+// releaseCommitPaths returns the paths a release commit holds: the release dir,
+// and its log and archive if they exist. The CLI and the TUI commit the same.
+func releaseCommitPaths(relPn, srcDir string) []string {
+	relDir := filepath.Join(srcDir, relPn)
+	paths := []string{relDir}
+	if logPath := releaseLogPath(relPn, srcDir); fileExists(logPath) {
+		paths = append(paths, logPath)
+	}
+	if archivePath := releaseArchivePath(relDir); archivePath != "" {
+		paths = append(paths, archivePath)
+	}
+	return paths
+}
+
+// gitCommitRelease commits paths, such as the release dir and its log. Other
+// changes, staged or not, are left out of the commit. It returns false if
+// there was nothing to commit.
+func gitCommitRelease(paths []string, msg string) (bool, error) {
+	_, err := git(".", append([]string{"add", "--"}, paths...)...)
+	if err != nil {
+		return false, err
+	}
+
+	changes, err := git(".", append([]string{"status", "--porcelain", "--"}, paths...)...)
+	if err != nil {
+		return false, err
+	}
+	if changes == "" {
+		return false, nil
+	}
+
+	_, err = git(".", append([]string{"commit", "-q", "-m", msg, "--"}, paths...)...)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// gitTagRelease creates an annotated tag named after the released IPN at
+// target, a commit or "HEAD"
+func gitTagRelease(relPn, target, msg string) error {
+	_, err := git(".", "tag", "-a", relPn, "-m", msg, target)
+	return err
+}
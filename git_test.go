@@ -0,0 +1,264 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupGitReleaseTree is setupReleaseTree with the tree committed to a new git
+// repo. It returns the partmaster dir.
+func setupGitReleaseTree(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	pmDir := setupReleaseTree(t)
+	root := filepath.Dir(pmDir)
+
+	gitT(t, root, "init", "-q")
+	gitT(t, root, "add", "-A")
+	gitT(t, root, "commit", "-q", "-m", "initial")
+
+	return pmDir
+}
+
+// gitT runs git in dir with an identity set, and fails the test on error
+func gitT(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	out, err := git(dir, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestReleaseAtRev(t *testing.T) {
+	pmDir := setupGitReleaseTree(t)
+	root := filepath.Dir(pmDir)
+
+	// sub-assembly packages must be released at the revision
+	release(t, "PCB-019-0001", pmDir)
+	release(t, "PCA-019-0000", pmDir)
+	gitT(t, root, "add", "-A")
+	gitT(t, root, "commit", "-q", "-m", "release PCA-019-0000")
+	gitT(t, root, "tag", "v1")
+	commit := gitT(t, root, "rev-parse", "HEAD")
+
+	// change the BOM after the tag, so the working tree differs from v1
+	bomPath := filepath.Join(root, "src", "ASY-001.csv")
+	err := os.WriteFile(bomPath, []byte("IPN,Qty\nSCR-002-0002,8\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dirty, err := gitIsDirty(".")
+	if err != nil || !dirty {
+		t.Fatalf("gitIsDirty() = %v, %v, want true", dirty, err)
+	}
+
+	var relLog strings.Builder
+	_, err = processReleaseAtRev("ASY-001-0000", &relLog, pmDir, "v1")
+	if err != nil {
+		t.Fatalf("release ASY-001-0000: %v\n%v", err, relLog.String())
+	}
+
+	if !strings.Contains(relLog.String(), "Source commit: "+commit+"\n") {
+		t.Errorf("release log does not record commit %v:\n%v", commit, relLog.String())
+	}
+
+	// the release is copied back to the working tree, made from the BOM at v1
+	b := bom{}
+	err = loadCSV(filepath.Join("ASY-001-0000", "ASY-001-0000.csv"), &b)
+	if err != nil {
+		t.Fatalf("error loading released BOM: %v", err)
+	}
+	if len(b) != 2 || b[0].IPN != "PCA-019-0000" || b[1].Qty != 4 {
+		t.Errorf("released BOM is not from v1: %v", b)
+	}
+
+	link, err := os.Readlink(filepath.Join("ASY-001-0000", "PCA-019-0000"))
+	if err != nil || link != filepath.Join("..", "elec", "PCA-019-0000") {
+		t.Errorf("sub-assembly link = %v, %v", link, err)
+	}
+
+	// a release at a revision replaces the release dir already there
+	relLog.Reset()
+	_, err = processReleaseAtRev("ASY-001-0000", &relLog, pmDir, "v1")
+	if err != nil {
+		t.Fatalf("release ASY-001-0000 again: %v\n%v", err, relLog.String())
+	}
+	link, err = os.Readlink(filepath.Join("ASY-001-0000", "PCA-019-0000"))
+	if err != nil || link != filepath.Join("..", "elec", "PCA-019-0000") {
+		t.Errorf("sub-assembly link after second release = %v, %v", link, err)
+	}
+
+	// the temporary worktree is cleaned up
+	worktrees := gitT(t, root, "worktree", "list")
+	if strings.Count(worktrees, "\n") != 0 {
+		t.Errorf("worktree left behind:\n%v", worktrees)
+	}
+}
+
+func TestReleaseAtRevFailed(t *testing.T) {
+	pmDir := setupGitReleaseTree(t)
+	root := filepath.Dir(pmDir)
+
+	// the screw has no MPN at v1, so a strict release fails
+	writeTree(t, pmDir, map[string]string{
+		"scr.csv": "IPN,Description,Manufacturer,MPN,Checked\nSCR-002-0002,screw #4,Screws Inc,,\n",
+	})
+	release(t, "PCB-019-0001", pmDir)
+	release(t, "PCA-019-0000", pmDir)
+	gitT(t, root, "add", "-A")
+	gitT(t, root, "commit", "-q", "-m", "release PCA-019-0000")
+	gitT(t, root, "tag", "v1")
+
+	writeTree(t, ".", map[string]string{"ASY-001-0000/keep.txt": "keep\n"})
+
+	strictRelease = true
+	t.Cleanup(func() { strictRelease = false })
+
+	var relLog strings.Builder
+	_, err := processReleaseAtRev("ASY-001-0000", &relLog, pmDir, "v1")
+	if err == nil {
+		t.Fatalf("strict release at v1 succeeded:\n%v", relLog.String())
+	}
+
+	// the release dir in the working tree is left as it was
+	if e, _ := exists(filepath.Join("ASY-001-0000", "keep.txt")); !e {
+		t.Errorf("failed release at a revision removed the release dir")
+	}
+	if e, _ := exists(filepath.Join("ASY-001-0000", "ASY-001-0000.csv")); e {
+		t.Errorf("failed release at a revision was copied back")
+	}
+}
+
+func TestReleaseAtRevDryRun(t *testing.T) {
+	pmDir := setupGitReleaseTree(t)
+	root := filepath.Dir(pmDir)
+
+	// the release at v1 is in the worktree, the local file only here
+	release(t, "PCB-019-0001", pmDir)
+	release(t, "PCA-019-0000", pmDir)
+	release(t, "ASY-001-0000", pmDir)
+	gitT(t, root, "add", "-A")
+	gitT(t, root, "commit", "-q", "-m", "release ASY-001-0000")
+	gitT(t, root, "tag", "v1")
+
+	writeTree(t, ".", map[string]string{"ASY-001-0000/localfile.txt": "local\n"})
+
+	dryRunRelease = true
+	t.Cleanup(func() { dryRunRelease = false })
+
+	var relLog strings.Builder
+	_, err := processReleaseAtRev("ASY-001-0000", &relLog, pmDir, "v1")
+	if err != nil {
+		t.Fatalf("dry run at v1: %v\n%v", err, relLog.String())
+	}
+	if !strings.Contains(relLog.String(), "Would write ASY-001-0000/ASY-001-0000.csv") {
+		t.Errorf("dry run at v1 prints no plan:\n%v", relLog.String())
+	}
+
+	// nothing is copied back into the working tree
+	if e, _ := exists(filepath.Join("ASY-001-0000", "localfile.txt")); !e {
+		t.Errorf("dry run at a revision replaced the release dir")
+	}
+}
+
+func TestCommitAndTagRelease(t *testing.T) {
+	pmDir := setupGitReleaseTree(t)
+	root := filepath.Dir(pmDir)
+
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	changelog := "# Changelog\n\n## [PCB-019-0001] - 2026-01-02\n\n- first board\n\n" +
+		"## [PCB-019-0000]\n\n- prototype\n"
+	err := os.WriteFile(filepath.Join("elec", "CHANGELOG.md"), []byte(changelog), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an unrelated change that must stay out of the release commit
+	err = os.WriteFile("notes.txt", []byte("wip"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relDir := release(t, "PCB-019-0001", pmDir)
+
+	msg := releaseMessage("PCB-019-0001", "elec")
+	if msg != "Release PCB-019-0001\n\n- first board\n" {
+		t.Errorf("releaseMessage() = %q", msg)
+	}
+
+	committed, err := gitCommitRelease(releaseCommitPaths("PCB-019-0001", "elec"), msg)
+	if err != nil || !committed {
+		t.Fatalf("gitCommitRelease() = %v, %v", committed, err)
+	}
+
+	files := gitT(t, root, "show", "--name-only", "--format=", "HEAD")
+	if strings.Contains(files, "notes.txt") || !strings.Contains(files, "src/elec/PCB-019-0001/") {
+		t.Errorf("wrong files in release commit:\n%v", files)
+	}
+
+	committed, err = gitCommitRelease([]string{relDir}, msg)
+	if err != nil || committed {
+		t.Errorf("second gitCommitRelease() = %v, %v, want nothing to commit", committed, err)
+	}
+
+	err = gitTagRelease("PCB-019-0001", "HEAD", msg)
+	if err != nil {
+		t.Fatalf("gitTagRelease() error: %v", err)
+	}
+
+	tagMsg := gitT(t, root, "tag", "-l", "--format=%(contents)", "PCB-019-0001")
+	if tagMsg != strings.TrimSpace(msg) {
+		t.Errorf("tag message = %q, want %q", tagMsg, msg)
+	}
+
+	if err := gitTagRelease("PCB-019-0001", "HEAD", msg); err == nil {
+		t.Error("tagging an existing release did not fail")
+	}
+}
+
+func TestReleaseTwiceClean(t *testing.T) {
+	pmDir := setupGitReleaseTree(t)
+	releaseArchive = archiveZip
+	t.Cleanup(func() { releaseArchive = "" })
+
+	for i := 0; i < 2; i++ {
+		dirty, err := gitIsDirty(".")
+		if err != nil || dirty {
+			t.Fatalf("before release %v: gitIsDirty() = %v, %v, want false", i+1, dirty, err)
+		}
+
+		for _, pn := range []string{"PCB-019-0001", "PCA-019-0000"} {
+			var relLog strings.Builder
+			srcDir, err := processRelease(pn, &relLog, pmDir)
+			if err != nil {
+				t.Fatalf("release %v of %v: %v\n%v", i+1, pn, err, relLog.String())
+			}
+			_, err = writeReleaseLog(pn, srcDir, relLog.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// changes to the source still count
+	err := os.WriteFile(filepath.Join("elec", "PCA-019.csv"), []byte("IPN,Qty\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirty, err := gitIsDirty(".")
+	if err != nil || !dirty {
+		t.Errorf("after a source change: gitIsDirty() = %v, %v, want true", dirty, err)
+	}
+}
@@ -11,13 +11,53 @@ import (
 
 type ipn string
 
-// reIpn is the single definition of the IPN format: CCC-NNNN-VVVV, and
+// The default patterns of the segments of an IPN: CCC-NNNN-VVVV, and
 // CCC-NNN-VVVV for legacy 3-digit N.
 //
 // VVVV codes a variation and is alphanumeric rather than digits only, because
 // it often encodes a value: 02V5 denotes 2.5 V, and 047n denotes 47 nH. Case is
 // significant, since SI prefixes such as the m in 8R3m (8.3 mOhm) rely on it.
-var reIpn = regexp.MustCompile(`^([A-Z][A-Z][A-Z])-(\d{3,4})-([0-9A-Za-z]{4})$`)
+const (
+	defaultIpnCategory  = `[A-Z][A-Z][A-Z]`
+	defaultIpnNumber    = `\d{3,4}`
+	defaultIpnVariation = `[0-9A-Za-z]{4}`
+)
+
+// reIpn is the single definition of the IPN format. The segment patterns can
+// be set in gitplm.yml, see setIpnScheme.
+var reIpn = regexp.MustCompile(ipnPattern(defaultIpnCategory, defaultIpnNumber, defaultIpnVariation))
+
+// reSourceBase matches the stem of a source BOM or YML file: CCC-NNN, or
+// CCC-NNN-VV with the first variationPrefixLen characters of the variation.
+var reSourceBase = regexp.MustCompile(sourceBasePattern(defaultIpnCategory, defaultIpnNumber, 2))
+
+// reCategoryFile matches partmaster files named after a category, such as
+// res.csv, in any case
+var reCategoryFile = regexp.MustCompile(categoryFilePattern(defaultIpnCategory))
+
+// variationPrefixLen is the number of characters of the variation in the
+// name of source files such as CCC-NNN-VV.csv
+var variationPrefixLen = 2
+
+func ipnPattern(c, n, v string) string {
+	return fmt.Sprintf(`^(%v)-(%v)-(%v)$`, c, n, v)
+}
+
+func sourceBasePattern(c, n string, prefixLen int) string {
+	if prefixLen <= 0 {
+		return fmt.Sprintf(`^(%v)-(%v)()$`, c, n)
+	}
+	return fmt.Sprintf(`^(%v)-(%v)(-[^-]{%v})?$`, c, n, prefixLen)
+}
+
+func categoryFilePattern(c string) string {
+	return fmt.Sprintf(`^(?i:%v)$`, c)
+}
+
+// variationPrefix returns the part of variation v used in source file names
+func variationPrefix(v string) string {
+	return v[:min(variationPrefixLen, len(v))]
+}
 
 func newIpn(s string) (ipn, error) {
 	_, _, _, err := ipn(s).parse()
@@ -73,7 +113,10 @@ func (i ipn) n() (int, error) {
 	return n, err
 }
 
-var ourIPNs = []string{"PCA", "PCB", "ASY", "DOC", "DFW", "DSW", "DCL", "FIX"}
+var defaultOurIPNs = []string{"PCA", "PCB", "ASY", "DOC", "DFW", "DSW", "DCL", "FIX"}
+
+// ourIPNs are the categories of parts we make, which are released
+var ourIPNs = defaultOurIPNs
 
 func (i ipn) isOurIPN() (bool, error) {
 	c, _, _, err := i.parse()
@@ -83,7 +126,10 @@ func (i ipn) isOurIPN() (bool, error) {
 	return lo.Contains(ourIPNs, c), nil
 }
 
-var boms = []string{"PCA", "ASY"}
+var defaultBoms = []string{"PCA", "ASY"}
+
+// boms are the categories of our parts that are assemblies with a BOM
+var boms = defaultBoms
 
 func (i ipn) hasBOM() (bool, error) {
 	c, _, _, err := i.parse()
@@ -92,3 +138,64 @@ func (i ipn) hasBOM() (bool, error) {
 	}
 	return lo.Contains(boms, c), nil
 }
+
+// setIpnScheme sets the IPN format and categories from the configuration,
+// using the defaults for any settings not given. It returns an error, and
+// changes nothing, if the configuration is not valid.
+func setIpnScheme(c IPNConfig) error {
+	category := lo.Ternary(c.Category != "", c.Category, defaultIpnCategory)
+	number := lo.Ternary(c.Number != "", c.Number, defaultIpnNumber)
+	variation := lo.Ternary(c.Variation != "", c.Variation, defaultIpnVariation)
+
+	for _, seg := range []struct{ name, pattern string }{
+		{"ipn.category", category},
+		{"ipn.number", number},
+		{"ipn.variation", variation},
+	} {
+		re, err := regexp.Compile(seg.pattern)
+		if err != nil {
+			return fmt.Errorf("%v: %v", seg.name, err)
+		}
+		if re.NumSubexp() > 0 {
+			return fmt.Errorf("%v: use (?:...) instead of capturing groups", seg.name)
+		}
+	}
+
+	prefixLen := 2
+	if c.VariationPrefix != nil {
+		prefixLen = *c.VariationPrefix
+	}
+	if prefixLen < 0 {
+		return fmt.Errorf("ipn.variationPrefix must not be negative")
+	}
+
+	ours := defaultOurIPNs
+	if c.Ours != nil {
+		ours = c.Ours
+	}
+	bomCats := defaultBoms
+	if c.BOMs != nil {
+		bomCats = c.BOMs
+	}
+
+	reCategory := regexp.MustCompile(`^(?:` + category + `)$`)
+	for _, cat := range append(append([]string{}, ours...), bomCats...) {
+		if !reCategory.MatchString(cat) {
+			return fmt.Errorf("category %v does not match ipn.category %v", cat, category)
+		}
+	}
+	for _, cat := range bomCats {
+		if !lo.Contains(ours, cat) {
+			return fmt.Errorf("ipn.boms: %v is not in ipn.ours, only our parts can have a BOM", cat)
+		}
+	}
+
+	reIpn = regexp.MustCompile(ipnPattern(category, number, variation))
+	reSourceBase = regexp.MustCompile(sourceBasePattern(category, number, prefixLen))
+	reCategoryFile = regexp.MustCompile(categoryFilePattern(category))
+	variationPrefixLen = prefixLen
+	ourIPNs = ours
+	boms = bomCats
+
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// defaultNewVariation is the variation of newly allocated IPNs
+const defaultNewVariation = "0001"
+
+// ipnAllocator hands out IPN numbers (the NNN of CCC-NNN-VVVV) that are not
+// used anywhere: in any partmaster file, in the source tree, or in a release.
+type ipnAllocator struct {
+	// used holds, for each CCC-NNN, where it is defined: the partmaster
+	// files that list it, and the source directories of our parts
+	used map[string]*ipnUse
+	// maxN and width are the highest N and the widest N of each category
+	maxN  map[string]int
+	width map[string]int
+}
+
+type ipnUse struct {
+	partmaster []string
+	source     []string
+}
+
+func newIpnAllocatorEmpty() *ipnAllocator {
+	return &ipnAllocator{
+		used:  map[string]*ipnUse{},
+		maxN:  map[string]int{},
+		width: map[string]int{},
+	}
+}
+
+// newIpnAllocator scans the partmaster files in pmDir, and the source BOMs,
+// release scripts, and release directories below the working directory, for
+// the IPNs in use.
+func newIpnAllocator(pmDir string) (*ipnAllocator, error) {
+	a := newIpnAllocatorEmpty()
+
+	if pmDir != "" {
+		c, err := loadAllCSVFiles(pmDir)
+		if err != nil {
+			return nil, fmt.Errorf("error loading partmaster: %v", err)
+		}
+		for _, f := range c.Files {
+			a.addRows(f.Rows, findHeaderIndex(f.Headers, "IPN"), f.Path)
+		}
+	}
+
+	// WalkDir does not follow symbolic links, so the sub-assemblies linked
+	// into a release are not seen twice
+	err := fs.WalkDir(os.DirFS("./"), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != "." && strings.HasPrefix(d.Name(), ".") {
+			return fs.SkipDir
+		}
+
+		name := d.Name()
+		dir := filepath.Dir(path)
+		if !d.IsDir() {
+			ext := filepath.Ext(name)
+			if ext != ".csv" && ext != ".yml" {
+				return nil
+			}
+			name = strings.TrimSuffix(name, ext)
+		}
+
+		if reIpn.MatchString(name) {
+			// a release directory or BOM, the source is the directory the
+			// release directory is in
+			if !d.IsDir() {
+				dir = filepath.Dir(dir)
+			}
+			a.add(ipn(name), "", dir)
+		} else if !d.IsDir() && reSourceBase.MatchString(name) {
+			a.addBase(name, dir)
+		}
+
+		if !d.IsDir() && filepath.Ext(d.Name()) == ".csv" && reSourceBase.MatchString(name) {
+			// the parts a source BOM uses may not be in the partmaster yet
+			b := bom{}
+			if err := loadCSV(path, &b); err != nil {
+				return fmt.Errorf("error loading BOM %v: %v", path, err)
+			}
+			for _, l := range b {
+				a.add(l.IPN, "", "")
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// addRows adds the IPNs in column ipnColIdx of the rows of partmaster file
+// path. Values that are not IPNs are skipped.
+func (a *ipnAllocator) addRows(rows [][]string, ipnColIdx int, path string) {
+	if ipnColIdx < 0 {
+		return
+	}
+	for _, row := range rows {
+		if ipnColIdx >= len(row) {
+			continue
+		}
+		a.add(ipn(strings.TrimSpace(row[ipnColIdx])), path, "")
+	}
+}
+
+// add records that pn is used, and where it is defined: in partmaster file
+// pmPath, or in source directory srcDir. Both may be "" for a part that is
+// only used, such as in a BOM.
+func (a *ipnAllocator) add(pn ipn, pmPath, srcDir string) {
+	c, n, _, err := pn.parse()
+	if err != nil {
+		return
+	}
+	a.use(c, n, pn.nWidth(), pmPath, srcDir)
+}
+
+// addBase records source file name CCC-NNN or CCC-NNN-VV in srcDir
+func (a *ipnAllocator) addBase(name, srcDir string) {
+	groups := reSourceBase.FindStringSubmatch(name)
+	if len(groups) < 3 {
+		return
+	}
+	n, err := strconv.Atoi(groups[2])
+	if err != nil {
+		return
+	}
+	a.use(groups[1], n, len(groups[2]), "", srcDir)
+}
+
+func (a *ipnAllocator) use(c string, n, width int, pmPath, srcDir string) {
+	if n > a.maxN[c] {
+		a.maxN[c] = n
+	}
+	a.width[c] = max(a.width[c], width)
+
+	base := fmt.Sprintf("%v-%0*v", c, width, n)
+	u, ok := a.used[base]
+	if !ok {
+		u = &ipnUse{}
+		a.used[base] = u
+	}
+	if pmPath != "" && !lo.Contains(u.partmaster, pmPath) {
+		u.partmaster = append(u.partmaster, pmPath)
+	}
+	if srcDir != "" && !lo.Contains(u.source, srcDir) {
+		u.source = append(u.source, srcDir)
+	}
+}
+
+// collisions describes the numbers of category that are defined more than
+// once: in several partmaster files, or in several source directories. Such
+// numbers were handed out twice, to different parts.
+func (a *ipnAllocator) collisions(category string) []string {
+	ret := []string{}
+	for base, u := range a.used {
+		if !strings.HasPrefix(base, category+"-") {
+			continue
+		}
+		if len(u.partmaster) > 1 {
+			ret = append(ret, fmt.Sprintf("%v is in several partmaster files: %v",
+				base, strings.Join(u.partmaster, ", ")))
+		}
+		if len(u.source) > 1 {
+			ret = append(ret, fmt.Sprintf("%v has several source directories: %v",
+				base, strings.Join(u.source, ", ")))
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// next returns CCC-NNN-VVVV with the number after the highest one in use in
+// category, with the N width of the category. The variation defaults to
+// defaultNewVariation.
+func (a *ipnAllocator) next(category, variation string) (ipn, error) {
+	if variation == "" {
+		variation = defaultNewVariation
+	}
+
+	n := a.maxN[category] + 1
+	width := max(a.width[category], len(fmt.Sprint(n)))
+
+	// a category not used yet starts at the narrowest width the scheme allows
+	widths := []int{width}
+	if a.width[category] == 0 {
+		widths = []int{3, 4, 5, 6}
+	}
+
+	for _, w := range widths {
+		pn := ipn(fmt.Sprintf("%v-%0*v-%v", category, max(w, width), n, variation))
+		if _, err := pn.c(); err != nil {
+			continue
+		}
+		// the next call hands out the number after this one
+		a.add(pn, "", "")
+		return pn, nil
+	}
+
+	return "", fmt.Errorf("%v-%v-%v is not a valid IPN", category, n, variation)
+}
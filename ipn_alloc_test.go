@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIpnAllocator(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+
+	writeTree(t, pmDir, map[string]string{
+		// RES-001 is also in res.csv
+		"res2.csv": `IPN,Description,Manufacturer,MPN
+RES-001-1003,10k 0402,Yageo,RC0402FR-0710KL
+RES-0007-4701,4.7k 0603,Yageo,RC0603FR-074K7L
+`,
+		"pca.csv": `IPN,Description
+PCA-019-0000,PCB assembly
+PCA-023-0000,display board
+`,
+	})
+	release(t, "PCB-019-0001", pmDir)
+
+	writeTree(t, ".", map[string]string{
+		// a board only in the source tree, and a resistor only in its BOM
+		"disp/PCA-021.csv": "IPN,Qty\nRES-0009-1001,1\n",
+		// a number handed out twice
+		"elec2/PCB-019.yml": "description: another board\n",
+	})
+
+	a, err := newIpnAllocator(pmDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		category, variation, want string
+	}{
+		{"RES", "", "RES-0010-0001"},
+		{"RES", "", "RES-0011-0001"},
+		{"PCA", "0000", "PCA-024-0000"},
+		{"PCB", "", "PCB-020-0001"},
+		{"CAP", "", "CAP-001-0001"},
+		{"ASY", "02V5", "ASY-002-02V5"},
+	}
+
+	for _, test := range tests {
+		got, err := a.next(test.category, test.variation)
+		if err != nil {
+			t.Fatalf("next(%v): %v", test.category, err)
+		}
+		if got.String() != test.want {
+			t.Errorf("next(%v) = %v, want %v", test.category, got, test.want)
+		}
+	}
+
+	if _, err := a.next("RES", "01"); err == nil {
+		t.Error("invalid variation did not fail")
+	}
+
+	exp := []string{"PCB-019 has several source directories: elec, elec2"}
+	if c := a.collisions("PCB"); !reflect.DeepEqual(c, exp) {
+		t.Errorf("collisions = %v, want %v", c, exp)
+	}
+	c := a.collisions("RES")
+	if len(c) != 1 || !strings.HasPrefix(c[0], "RES-001 is in several partmaster files: ") {
+		t.Errorf("RES collisions = %v", c)
+	}
+}
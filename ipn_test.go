@@ -69,3 +69,59 @@ func TestIpnBase(t *testing.T) {
 		}
 	}
 }
+
+func TestIpnScheme(t *testing.T) {
+	t.Cleanup(func() {
+		if err := setIpnScheme(IPNConfig{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	prefix := 3
+	err := setIpnScheme(IPNConfig{
+		Category:        `[A-Z]{3,4}`,
+		Number:          `\d{3}`,
+		Ours:            []string{"PCA", "PCB", "ASY", "GTWY"},
+		BOMs:            []string{"PCA", "ASY", "GTWY"},
+		VariationPrefix: &prefix,
+	})
+	if err != nil {
+		t.Fatalf("setIpnScheme() error: %v", err)
+	}
+
+	pn, err := newIpn("GTWY-001-0102")
+	if err != nil {
+		t.Fatalf("newIpn() error: %v", err)
+	}
+	if hasBOM, _ := pn.hasBOM(); !hasBOM {
+		t.Error("GTWY has no BOM")
+	}
+	if pn.base() != "GTWY-001" {
+		t.Errorf("base() = %v", pn.base())
+	}
+	if _, err := newIpn("PCB-0001-0001"); err == nil {
+		t.Error("4 digit N accepted")
+	}
+	if !reSourceBase.MatchString("GTWY-001-010") || reSourceBase.MatchString("GTWY-001-01") {
+		t.Error("source base does not use the variation prefix length")
+	}
+
+	negative := -1
+	invalid := []IPNConfig{
+		{Category: `[A-Z`},
+		{Number: `(\d+)`},
+		{BOMs: []string{"PCA", "GTW"}},
+		{Ours: []string{"PCA", "pcb"}},
+		{VariationPrefix: &negative},
+	}
+	for _, c := range invalid {
+		if err := setIpnScheme(c); err == nil {
+			t.Errorf("setIpnScheme(%+v) accepted", c)
+		}
+	}
+
+	// an invalid scheme changes nothing
+	if _, err := newIpn("GTWY-001-0102"); err != nil {
+		t.Errorf("scheme changed by invalid config: %v", err)
+	}
+}
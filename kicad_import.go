@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/samber/lo"
+)
+
+// kicadComponent is one placed component, as read from a KiCad netlist or
+// schematic, before components are grouped into BOM lines.
+type kicadComponent struct {
+	Ref         string
+	Value       string
+	CmpName     string
+	Footprint   string
+	Description string
+	Datasheet   string
+	IPN         string
+}
+
+// loadKiCadBom reads a KiCad XML netlist, S-expression netlist, or
+// S-expression schematic (.kicad_sch), and returns the components grouped into
+// a BOM by IPN.
+func loadKiCadBom(fileName string, logErr func(string)) (bom, error) {
+	var comps []kicadComponent
+	var err error
+
+	if strings.HasSuffix(fileName, ".kicad_sch") {
+		comps, err = loadKiCadSchematic(fileName, map[string]bool{})
+	} else {
+		comps, err = loadKiCadNetlist(fileName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return kicadComponentsToBom(comps, logErr), nil
+}
+
+// importKiCadBom writes the BOM of a KiCad netlist or schematic to a CSV file
+func importKiCadBom(fileName, bomPath string, logErr func(string)) error {
+	b, err := loadKiCadBom(fileName, logErr)
+	if err != nil {
+		return err
+	}
+
+	err = saveSourceBomCSV(bomPath, b)
+	if err != nil {
+		return fmt.Errorf("Error writing BOM %v: %v", bomPath, err)
+	}
+
+	logErr(fmt.Sprintf("%v: %v BOM lines imported from %v\n", bomPath, len(b), fileName))
+
+	return nil
+}
+
+// kicadComponentsToBom groups components by their IPN field. Components
+// without an IPN are grouped by value and footprint, and reported through
+// logErr so they can be fixed in the schematic.
+func kicadComponentsToBom(comps []kicadComponent, logErr func(string)) bom {
+	b := bom{}
+	lines := map[string]*bomLine{}
+
+	for _, c := range comps {
+		key := c.IPN
+		if key == "" {
+			logErr(fmt.Sprintf("Component %v (%v) has no IPN\n", c.Ref, c.Value))
+			key = "|" + c.Value + "|" + c.Footprint
+		}
+
+		l, ok := lines[key]
+		if !ok {
+			l = &bomLine{
+				IPN:         ipn(c.IPN),
+				Value:       c.Value,
+				CmpName:     c.CmpName,
+				Footprint:   c.Footprint,
+				Description: c.Description,
+				Datasheet:   c.Datasheet,
+			}
+			lines[key] = l
+			b = append(b, l)
+		}
+
+		l.Ref += " " + c.Ref
+		l.Qty++
+	}
+
+	for _, l := range b {
+		l.sortRefs()
+	}
+
+	sort.Sort(b)
+
+	return b
+}
+
+// kicadDatasheet drops the "~" KiCad uses for an empty datasheet field
+func kicadDatasheet(s string) string {
+	if s == "~" {
+		return ""
+	}
+	return s
+}
+
+type kicadXMLField struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+type kicadXMLProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type kicadXMLComp struct {
+	Ref         string             `xml:"ref,attr"`
+	Value       string             `xml:"value"`
+	Footprint   string             `xml:"footprint"`
+	Datasheet   string             `xml:"datasheet"`
+	Description string             `xml:"description"`
+	Fields      []kicadXMLField    `xml:"fields>field"`
+	Properties  []kicadXMLProperty `xml:"property"`
+	Libsource   struct {
+		Part        string `xml:"part,attr"`
+		Description string `xml:"description,attr"`
+	} `xml:"libsource"`
+}
+
+type kicadXMLNetlist struct {
+	Components []kicadXMLComp `xml:"components>comp"`
+}
+
+// loadKiCadNetlist reads a netlist in either the XML format KiCad hands to BOM
+// plugins or the S-expression format of File → Export → Netlist.
+func loadKiCadNetlist(fileName string) ([]kicadComponent, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '(' {
+		root, err := parseSexpr(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing netlist %v: %v", fileName, err)
+		}
+		return kicadSexprNetlistComponents(root), nil
+	}
+
+	n := kicadXMLNetlist{}
+	err = xml.Unmarshal(data, &n)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing netlist %v: %v", fileName, err)
+	}
+
+	ret := []kicadComponent{}
+	for _, xc := range n.Components {
+		excluded := false
+		c := kicadComponent{
+			Ref:         xc.Ref,
+			Value:       xc.Value,
+			CmpName:     xc.Libsource.Part,
+			Footprint:   xc.Footprint,
+			Description: xc.Description,
+			Datasheet:   kicadDatasheet(xc.Datasheet),
+		}
+		if c.Description == "" {
+			c.Description = xc.Libsource.Description
+		}
+		for _, f := range xc.Fields {
+			if strings.EqualFold(f.Name, "IPN") {
+				c.IPN = strings.TrimSpace(f.Value)
+			}
+		}
+		for _, p := range xc.Properties {
+			switch {
+			case strings.EqualFold(p.Name, "IPN") && c.IPN == "":
+				c.IPN = strings.TrimSpace(p.Value)
+			case p.Name == "dnp", p.Name == "exclude_from_bom":
+				excluded = true
+			}
+		}
+		if !excluded {
+			ret = append(ret, c)
+		}
+	}
+
+	return ret, nil
+}
+
+func kicadSexprNetlistComponents(root *sexpr) []kicadComponent {
+	ret := []kicadComponent{}
+
+	comps := root.find("components")
+	if comps == nil {
+		return ret
+	}
+
+	for _, sc := range comps.findAll("comp") {
+		excluded := false
+		c := kicadComponent{
+			Ref:         sc.find("ref").arg(0),
+			Value:       sc.find("value").arg(0),
+			Footprint:   sc.find("footprint").arg(0),
+			Datasheet:   kicadDatasheet(sc.find("datasheet").arg(0)),
+			Description: sc.find("description").arg(0),
+		}
+		if ls := sc.find("libsource"); ls != nil {
+			c.CmpName = ls.find("part").arg(0)
+			if c.Description == "" {
+				c.Description = ls.find("description").arg(0)
+			}
+		}
+		if fields := sc.find("fields"); fields != nil {
+			for _, f := range fields.findAll("field") {
+				if strings.EqualFold(f.find("name").arg(0), "IPN") {
+					c.IPN = strings.TrimSpace(f.arg(1))
+				}
+			}
+		}
+		for _, p := range sc.findAll("property") {
+			name := p.find("name").arg(0)
+			switch {
+			case strings.EqualFold(name, "IPN") && c.IPN == "":
+				c.IPN = strings.TrimSpace(p.find("value").arg(0))
+			case name == "dnp", name == "exclude_from_bom":
+				excluded = true
+			}
+		}
+		if !excluded {
+			ret = append(ret, c)
+		}
+	}
+
+	return ret
+}
+
+// loadKiCadSchematic reads the symbols of a schematic and of every sheet it
+// includes. Symbols excluded from the BOM, marked DNP, or that are power
+// symbols (#PWR, #FLG) are skipped. A multi-unit part is reported once.
+func loadKiCadSchematic(fileName string, visited map[string]bool) ([]kicadComponent, error) {
+	if visited[fileName] {
+		return nil, nil
+	}
+	visited[fileName] = true
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	root, err := parseSexpr(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing schematic %v: %v", fileName, err)
+	}
+
+	ret := []kicadComponent{}
+	seen := map[string]bool{}
+
+	for _, s := range root.findAll("symbol") {
+		if s.find("in_bom").arg(0) == "no" || s.find("dnp").arg(0) == "yes" {
+			continue
+		}
+
+		props := map[string]string{}
+		for _, p := range s.findAll("property") {
+			props[strings.ToUpper(p.arg(0))] = p.arg(1)
+		}
+
+		c := kicadComponent{
+			Value:       props["VALUE"],
+			Footprint:   props["FOOTPRINT"],
+			Datasheet:   kicadDatasheet(props["DATASHEET"]),
+			Description: props["DESCRIPTION"],
+			IPN:         strings.TrimSpace(props["IPN"]),
+		}
+		libID := s.find("lib_id").arg(0)
+		c.CmpName = libID[strings.LastIndex(libID, ":")+1:]
+
+		// a sheet used more than once lists the reference of each instance
+		refs := []string{}
+		if inst := s.find("instances"); inst != nil {
+			for _, project := range inst.findAll("project") {
+				for _, p := range project.findAll("path") {
+					refs = append(refs, p.find("reference").arg(0))
+				}
+			}
+		}
+		if len(refs) == 0 {
+			refs = append(refs, props["REFERENCE"])
+		}
+
+		for _, ref := range refs {
+			if ref == "" || strings.HasPrefix(ref, "#") || seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			c.Ref = ref
+			ret = append(ret, c)
+		}
+	}
+
+	for _, sheet := range root.findAll("sheet") {
+		sheetFile := ""
+		for _, p := range sheet.findAll("property") {
+			if p.arg(0) == "Sheetfile" || p.arg(0) == "Sheet file" {
+				sheetFile = p.arg(1)
+			}
+		}
+		if sheetFile == "" {
+			continue
+		}
+		sub, err := loadKiCadSchematic(filepath.Join(filepath.Dir(fileName), sheetFile), visited)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range sub {
+			if !seen[c.Ref] {
+				seen[c.Ref] = true
+				ret = append(ret, c)
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// kicadBomPath returns the CSV a KiCad BOM in dir should be written to. Like
+// the gitplm_bom.py plugin, it is named after the release configuration file
+// (CCC-NNN.yml) of an assembly in the same directory, or failing that after
+// an existing BOM.
+func kicadBomPath(dir string) (string, error) {
+	for _, ext := range []string{".yml", ".csv"} {
+		files, err := filepath.Glob(filepath.Join(dir, "*"+ext))
+		if err != nil {
+			return "", err
+		}
+
+		found := []string{}
+		for _, f := range files {
+			stem := strings.TrimSuffix(filepath.Base(f), ext)
+			groups := reSourceBase.FindStringSubmatch(stem)
+			if groups == nil {
+				continue
+			}
+			if lo.Contains(boms, groups[1]) {
+				found = append(found, stem)
+			}
+		}
+
+		if len(found) == 1 {
+			return filepath.Join(dir, found[0]+".csv"), nil
+		}
+		if len(found) > 1 {
+			return "", fmt.Errorf("More than one assembly in %v (%v), please specify the output file",
+				dir, strings.Join(found, ", "))
+		}
+	}
+
+	return "", fmt.Errorf("Did not find a CCC-NNN.yml file in %v, please create one so we know the part number for this BOM", dir)
+}
+
+// sexpr is a node of a KiCad S-expression file. A node is either an atom or a
+// list whose first element names it, as in (property "IPN" "RES-001-0001").
+type sexpr struct {
+	atom   string
+	isList bool
+	list   []*sexpr
+}
+
+// name returns the first atom of a list
+func (s *sexpr) name() string {
+	if s == nil || !s.isList || len(s.list) == 0 || s.list[0].isList {
+		return ""
+	}
+	return s.list[0].atom
+}
+
+// arg returns the i'th atom following the name of a list, or "" if missing
+func (s *sexpr) arg(i int) string {
+	if s == nil || !s.isList || len(s.list) <= i+1 || s.list[i+1].isList {
+		return ""
+	}
+	return s.list[i+1].atom
+}
+
+// find returns the first child list with the given name
+func (s *sexpr) find(name string) *sexpr {
+	if s == nil {
+		return nil
+	}
+	for _, c := range s.list {
+		if c.name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// findAll returns all child lists with the given name
+func (s *sexpr) findAll(name string) []*sexpr {
+	ret := []*sexpr{}
+	if s == nil {
+		return ret
+	}
+	for _, c := range s.list {
+		if c.name() == name {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+func parseSexpr(r io.Reader) (*sexpr, error) {
+	br, ok := r.(io.RuneScanner)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	stack := []*sexpr{}
+	var root *sexpr
+
+	for {
+		ch, _, err := br.ReadRune()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case unicode.IsSpace(ch):
+			continue
+		case ch == '(':
+			n := &sexpr{isList: true}
+			if len(stack) > 0 {
+				top := stack[len(stack)-1]
+				top.list = append(top.list, n)
+			}
+			stack = append(stack, n)
+		case ch == ')':
+			if len(stack) == 0 {
+				return nil, errors.New("unbalanced )")
+			}
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 && root == nil {
+				root = n
+			}
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unexpected %q outside of a list", ch)
+			}
+			var atom strings.Builder
+			if ch == '"' {
+				for {
+					c, _, err := br.ReadRune()
+					if err != nil {
+						return nil, errors.New("unterminated string")
+					}
+					if c == '"' {
+						break
+					}
+					if c == '\\' {
+						c, _, err = br.ReadRune()
+						if err != nil {
+							return nil, errors.New("unterminated string")
+						}
+						if c == 'n' {
+							c = '\n'
+						}
+					}
+					atom.WriteRune(c)
+				}
+			} else {
+				atom.WriteRune(ch)
+				for {
+					c, _, err := br.ReadRune()
+					if err != nil {
+						break
+					}
+					if unicode.IsSpace(c) || c == '(' || c == ')' {
+						_ = br.UnreadRune()
+						break
+					}
+					atom.WriteRune(c)
+				}
+			}
+			top := stack[len(stack)-1]
+			top.list = append(top.list, &sexpr{atom: atom.String()})
+		}
+	}
+
+	if len(stack) > 0 {
+		return nil, errors.New("unbalanced (")
+	}
+	if root == nil {
+		return nil, errors.New("no data")
+	}
+
+	return root, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var netlistXML = `<?xml version="1.0" encoding="UTF-8"?>
+<export version="E">
+  <components>
+    <comp ref="R10">
+      <value>10k</value>
+      <footprint>Resistor_SMD:R_0603_1608Metric</footprint>
+      <datasheet>~</datasheet>
+      <fields>
+        <field name="IPN">RES-001-1002</field>
+      </fields>
+      <libsource lib="Device" part="R" description="Resistor"/>
+    </comp>
+    <comp ref="R2">
+      <value>10k</value>
+      <footprint>Resistor_SMD:R_0603_1608Metric</footprint>
+      <fields>
+        <field name="IPN">RES-001-1002</field>
+      </fields>
+      <libsource lib="Device" part="R" description="Resistor"/>
+    </comp>
+    <comp ref="C1">
+      <value>100n</value>
+      <libsource lib="Device" part="C"/>
+      <property name="IPN" value="CAP-000-1001"/>
+    </comp>
+    <comp ref="C2">
+      <value>100n</value>
+      <libsource lib="Device" part="C"/>
+      <property name="IPN" value="CAP-000-1001"/>
+      <property name="dnp"/>
+    </comp>
+  </components>
+</export>
+`
+
+var schematic = `(kicad_sch (version 20231120) (generator "eeschema")
+  (lib_symbols
+    (symbol "Device:R" (property "Reference" "R"))
+  )
+  (symbol (lib_id "Device:R") (at 10 10 0) (unit 1) (in_bom yes) (on_board yes) (dnp no)
+    (property "Reference" "R1" (at 0 0 0))
+    (property "Value" "10k" (at 0 0 0))
+    (property "Footprint" "Resistor_SMD:R_0603_1608Metric" (at 0 0 0))
+    (property "Datasheet" "~" (at 0 0 0))
+    (property "IPN" "RES-001-1002" (at 0 0 0))
+  )
+  (symbol (lib_id "Device:R") (at 20 10 0) (unit 1) (in_bom yes) (on_board yes) (dnp yes)
+    (property "Reference" "R3" (at 0 0 0))
+    (property "IPN" "RES-001-1002" (at 0 0 0))
+  )
+  (symbol (lib_id "Amplifier:LT1716") (at 30 10 0) (unit 1) (in_bom yes) (on_board yes)
+    (property "Reference" "U1" (at 0 0 0))
+    (property "Value" "LT1716" (at 0 0 0))
+    (property "IPN" "ANA-000-0000" (at 0 0 0))
+  )
+  (symbol (lib_id "Amplifier:LT1716") (at 40 10 0) (unit 2) (in_bom yes) (on_board yes)
+    (property "Reference" "U1" (at 0 0 0))
+    (property "Value" "LT1716" (at 0 0 0))
+    (property "IPN" "ANA-000-0000" (at 0 0 0))
+  )
+  (symbol (lib_id "power:GND") (at 50 10 0) (unit 1) (in_bom yes) (on_board yes)
+    (property "Reference" "#PWR01" (at 0 0 0))
+  )
+  (sheet (at 0 0) (size 10 10)
+    (property "Sheetname" "sub" (at 0 0 0))
+    (property "Sheetfile" "sub.kicad_sch" (at 0 0 0))
+  )
+)
+`
+
+var subSchematic = `(kicad_sch (version 20231120) (generator "eeschema")
+  (symbol (lib_id "Device:R") (at 10 10 0) (unit 1) (in_bom yes) (on_board yes)
+    (property "Reference" "R?" (at 0 0 0))
+    (property "Value" "10k" (at 0 0 0))
+    (property "IPN" "RES-001-1002" (at 0 0 0))
+    (instances
+      (project "test"
+        (path "/a/b" (reference "R20") (unit 1))
+        (path "/a/c" (reference "R21") (unit 1))
+      )
+    )
+  )
+)
+`
+
+func TestLoadKiCadNetlist(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "test.xml")
+	if err := os.WriteFile(fn, []byte(netlistXML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := loadKiCadBom(fn, func(string) {})
+	if err != nil {
+		t.Fatalf("loadKiCadBom() error: %v", err)
+	}
+
+	if len(b) != 2 {
+		t.Fatalf("expected 2 BOM lines, got %v: %v", len(b), b)
+	}
+
+	if b[0].IPN != "CAP-000-1001" || b[0].Ref != "C1" || b[0].Qty != 1 {
+		t.Errorf("wrong CAP line: %v", b[0])
+	}
+
+	if b[1].IPN != "RES-001-1002" || b[1].Ref != "R2 R10" || b[1].Qty != 2 {
+		t.Errorf("wrong RES line: %v", b[1])
+	}
+
+	if b[1].CmpName != "R" || b[1].Description != "Resistor" || b[1].Datasheet != "" {
+		t.Errorf("wrong RES fields: %v", b[1])
+	}
+}
+
+func TestLoadKiCadSchematic(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "test.kicad_sch")
+	if err := os.WriteFile(fn, []byte(schematic), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub.kicad_sch"), []byte(subSchematic), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := loadKiCadBom(fn, func(string) {})
+	if err != nil {
+		t.Fatalf("loadKiCadBom() error: %v", err)
+	}
+
+	if len(b) != 2 {
+		t.Fatalf("expected 2 BOM lines, got %v: %v", len(b), b)
+	}
+
+	if b[0].IPN != "ANA-000-0000" || b[0].Ref != "U1" || b[0].Qty != 1 || b[0].CmpName != "LT1716" {
+		t.Errorf("wrong ANA line: %v", b[0])
+	}
+
+	if b[1].IPN != "RES-001-1002" || b[1].Ref != "R1 R20 R21" || b[1].Qty != 3 {
+		t.Errorf("wrong RES line: %v", b[1])
+	}
+}
+
+func TestKiCadBomPath(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"PCA-019.yml", "PCB-019.yml", "notes.yml"} {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := kicadBomPath(dir)
+	if err != nil {
+		t.Fatalf("kicadBomPath() error: %v", err)
+	}
+
+	if want := filepath.Join(dir, "PCA-019.csv"); got != want {
+		t.Errorf("kicadBomPath() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// electricalCategories are the IPN categories placed on schematics, whose
+// parts need a KiCad symbol and footprint
+var electricalCategories = []string{
+	"ANA", "ANT", "CAP", "CNT", "DIO", "FER", "FUS", "ICS", "IND", "LED",
+	"OSC", "REL", "RES", "SNS", "TRF", "XTL",
+}
+
+// lintIssue is a problem found in the partmaster
+type lintIssue struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	IPN     string `json:"ipn,omitempty"`
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+func (i lintIssue) String() string {
+	loc := i.File
+	if i.Line > 0 {
+		loc += fmt.Sprintf(":%v", i.Line)
+	}
+	if i.IPN != "" {
+		loc += ": " + i.IPN
+	}
+	return fmt.Sprintf("%v: %v [%v]", loc, i.Message, i.Check)
+}
+
+// lintPartmaster checks the partmaster files of a collection and returns every
+// problem found, ordered by file and line
+func lintPartmaster(c *CSVFileCollection) []lintIssue {
+	issues := []lintIssue{}
+
+	for path, err := range c.LoadErrors {
+		issues = append(issues, lintIssue{File: path, Check: "load",
+			Message: fmt.Sprintf("could not be loaded: %v", err)})
+	}
+
+	// where each IPN and MPN pair was first seen
+	seen := map[string]string{}
+
+	for _, f := range c.Files {
+		issues = append(issues, lintFile(f, seen)...)
+	}
+
+	issues = append(issues, lintColumns(c.Files)...)
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].File != issues[j].File {
+			return issues[i].File < issues[j].File
+		}
+		return issues[i].Line < issues[j].Line
+	})
+
+	return issues
+}
+
+func lintFile(f *CSVFile, seen map[string]string) []lintIssue {
+	issues := []lintIssue{}
+	add := func(line int, pn, check, format string, args ...any) {
+		issues = append(issues, lintIssue{File: f.Path, Line: line, IPN: pn,
+			Check: check, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, m := range f.Malformed {
+		add(m.Line, "", "malformed", "row could not be read: %v", m.Err)
+	}
+
+	col := map[string]int{}
+	for i, h := range f.Headers {
+		col[h] = i
+	}
+
+	ipnIdx, ok := col["IPN"]
+	if !ok {
+		add(1, "", "columns", "no IPN column")
+		return issues
+	}
+
+	stem := strings.TrimSuffix(f.Name, filepath.Ext(f.Name))
+	fileCategory := ""
+	if reCategoryFile.MatchString(stem) {
+		fileCategory = strings.ToUpper(stem)
+	}
+
+	value := func(row []string, name string) string {
+		i, ok := col[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	_, hasSymbol := col["Symbol"]
+	_, hasFootprint := col["Footprint"]
+	electrical := false
+
+	skipped := 0
+	for i, row := range f.Rows {
+		// rows are assumed to be one line each, after the header and the
+		// malformed rows before them
+		line := i + 2 + skipped
+		for skipped < len(f.Malformed) && f.Malformed[skipped].Line <= line {
+			skipped++
+			line++
+		}
+
+		if len(row) != len(f.Headers) {
+			add(line, "", "malformed", "row has %v fields, header has %v", len(row), len(f.Headers))
+		}
+
+		if ipnIdx >= len(row) || strings.TrimSpace(row[ipnIdx]) == "" {
+			add(line, "", "ipn", "IPN is empty")
+			continue
+		}
+
+		pn, err := newIpn(strings.TrimSpace(row[ipnIdx]))
+		if err != nil {
+			add(line, row[ipnIdx], "ipn", "not a valid IPN")
+			continue
+		}
+
+		c, _ := pn.c()
+		if fileCategory != "" && c != fileCategory {
+			add(line, pn.String(), "category", "category %v does not match file %v", c, f.Name)
+		}
+
+		mpn := value(row, "MPN")
+		key := pn.String() + "\x00" + mpn
+		if first, ok := seen[key]; ok {
+			add(line, pn.String(), "duplicate", "same IPN and MPN as %v", first)
+		} else {
+			seen[key] = fmt.Sprintf("%v:%v", f.Path, line)
+		}
+
+		if lo.Contains(electricalCategories, c) {
+			electrical = true
+			if hasSymbol && value(row, "Symbol") == "" {
+				add(line, pn.String(), "symbol", "no Symbol")
+			}
+			if hasFootprint && value(row, "Footprint") == "" {
+				add(line, pn.String(), "footprint", "no Footprint")
+			}
+		}
+
+		if ds := value(row, "Datasheet"); ds != "" {
+			u, err := url.Parse(ds)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				add(line, pn.String(), "datasheet", "datasheet %v is not a URL", ds)
+			}
+		}
+	}
+
+	// one issue for a missing column, rather than one for every part
+	if electrical && !hasSymbol {
+		add(1, "", "symbol", "no Symbol column for electrical parts")
+	}
+	if electrical && !hasFootprint {
+		add(1, "", "footprint", "no Footprint column for electrical parts")
+	}
+
+	return issues
+}
+
+// lintColumns reports files that lack a column most other files have, as the
+// partmaster is expected to use the same columns throughout, apart from those
+// specific to a category
+func lintColumns(files []*CSVFile) []lintIssue {
+	issues := []lintIssue{}
+
+	count := map[string]int{}
+	for _, f := range files {
+		for _, h := range lo.Uniq(f.Headers) {
+			count[h]++
+		}
+	}
+
+	common := []string{}
+	for h, n := range count {
+		if n*2 > len(files) {
+			common = append(common, h)
+		}
+	}
+	sort.Strings(common)
+
+	for _, f := range files {
+		for _, h := range common {
+			if !lo.Contains(f.Headers, h) {
+				issues = append(issues, lintIssue{File: f.Path, Line: 1, Check: "columns",
+					Message: fmt.Sprintf("no %v column, which %v of %v files have", h, count[h], len(files))})
+			}
+		}
+	}
+
+	return issues
+}
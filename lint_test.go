@@ -0,0 +1,101 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLintPartmaster(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"res.csv": `IPN,Description,Symbol,Footprint,Manufacturer,MPN,Datasheet
+RES-001-1002,10k,Device:R,R_0603,Yageo,RC0603FR-0710KL,https://example.com/rc.pdf
+RES-001-1003,100k,Device:R,,Yageo,RC0603FR-07100KL,rc.pdf
+CAP-001-0001,1u,Device:C,C_0603,Murata,GRM1,
+RES-001-1002,10k,Device:R,R_0603,Yageo,RC0603FR-0710KL
+RES-01-1004,1M,Device:R,R_0603,Yageo,RC0603FR-071ML,
+`,
+		"cap.csv": `IPN,Description,Footprint,Manufacturer,MPN,Datasheet
+CAP-001-0001,1u,C_0603,Murata,GRM1,
+`,
+		"scr.csv": `IPN,Description,Manufacturer,MPN
+SCR-002-0002,screw,Screws Inc,S4
+`,
+	})
+
+	c, err := loadAllCSVFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issues := lintPartmaster(c)
+
+	got := []lintIssue{}
+	for _, i := range issues {
+		i.File = filepath.Base(i.File)
+		i.Message = ""
+		got = append(got, i)
+	}
+
+	exp := []lintIssue{
+		{File: "cap.csv", Line: 1, Check: "symbol"},
+		{File: "res.csv", Line: 3, IPN: "RES-001-1003", Check: "footprint"},
+		{File: "res.csv", Line: 3, IPN: "RES-001-1003", Check: "datasheet"},
+		{File: "res.csv", Line: 4, IPN: "CAP-001-0001", Check: "category"},
+		{File: "res.csv", Line: 4, IPN: "CAP-001-0001", Check: "duplicate"},
+		{File: "res.csv", Line: 5, Check: "malformed"},
+		{File: "res.csv", Line: 5, IPN: "RES-001-1002", Check: "duplicate"},
+		{File: "res.csv", Line: 6, IPN: "RES-01-1004", Check: "ipn"},
+		{File: "scr.csv", Line: 1, Check: "columns"},
+		{File: "scr.csv", Line: 1, Check: "columns"},
+	}
+
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("lint issues:\n%+v\nwant\n%+v", got, exp)
+	}
+
+	if issues[4].Message != "same IPN and MPN as "+filepath.Join(dir, "cap.csv")+":2" {
+		t.Errorf("duplicate message: %v", issues[4].Message)
+	}
+	if issues[9].Message != "no Footprint column, which 2 of 3 files have" {
+		t.Errorf("columns message: %v", issues[9].Message)
+	}
+}
+
+func TestLintCategoryScheme(t *testing.T) {
+	t.Cleanup(func() {
+		if err := setIpnScheme(IPNConfig{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	err := setIpnScheme(IPNConfig{Category: `[A-Z]{3,4}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"gtwy.csv": `IPN,Description,Manufacturer,MPN
+GTWY-001-0001,gateway,mycompany,
+SCR-002-0002,screw,Screws Inc,S4
+`,
+	})
+
+	c, err := loadAllCSVFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// files are named after the categories of the configured scheme
+	got := []lintIssue{}
+	for _, i := range lintPartmaster(c) {
+		i.File = filepath.Base(i.File)
+		i.Message = ""
+		got = append(got, i)
+	}
+	exp := []lintIssue{{File: "gtwy.csv", Line: 3, IPN: "SCR-002-0002", Check: "category"}}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("lint issues:\n%+v\nwant\n%+v", got, exp)
+	}
+}
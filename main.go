@@ -1,14 +1,18 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/samber/lo"
 )
@@ -18,6 +22,13 @@ var version = "Development"
 func main() {
 	initCSV()
 
+	// the IPN scheme applies to every command, so the config is loaded and
+	// checked up front
+	if _, err := loadConfig(); err != nil {
+		log.Printf("Error loading config: %v", err)
+		os.Exit(1)
+	}
+
 	if len(os.Args) < 2 {
 		cmdTUI()
 		return
@@ -29,6 +40,22 @@ func main() {
 	switch command {
 	case "release":
 		cmdRelease(args)
+	case "release-all":
+		cmdReleaseAll(args)
+	case "verify":
+		cmdVerify(args)
+	case "where-used":
+		cmdWhereUsed(args)
+	case "diff":
+		cmdDiff(args)
+	case "lint":
+		cmdLint(args)
+	case "new-ipn":
+		cmdNewIPN(args)
+	case "bump":
+		cmdBump(args)
+	case "import-kicad":
+		cmdImportKiCad(args)
 	case "simplify":
 		cmdSimplify(args)
 	case "combine":
@@ -51,6 +78,14 @@ func printUsage() {
 	fmt.Fprintf(os.Stderr, "Commands:\n")
 	fmt.Fprintf(os.Stderr, "  (no command)                    Launch interactive TUI\n")
 	fmt.Fprintf(os.Stderr, "  release <IPN>                   Process release for IPN\n")
+	fmt.Fprintf(os.Stderr, "  release-all                     Release every part with a source in the tree\n")
+	fmt.Fprintf(os.Stderr, "  verify <release-dir>            Check release against its manifest\n")
+	fmt.Fprintf(os.Stderr, "  where-used <IPN>                List assemblies that use IPN\n")
+	fmt.Fprintf(os.Stderr, "  diff <IPN|file> <IPN|file>      Compare two BOMs\n")
+	fmt.Fprintf(os.Stderr, "  lint                            Check the partmaster for problems\n")
+	fmt.Fprintf(os.Stderr, "  new-ipn <CCC>                   Allocate the next free IPN in a category\n")
+	fmt.Fprintf(os.Stderr, "  bump <IPN>                      Start the next variation of a part you make\n")
+	fmt.Fprintf(os.Stderr, "  import-kicad <file>             Import BOM from KiCad netlist/schematic\n")
 	fmt.Fprintf(os.Stderr, "  simplify <file> -out <file>     Simplify a BOM file\n")
 	fmt.Fprintf(os.Stderr, "  combine <file> -out <file>      Combine BOM into output\n")
 	fmt.Fprintf(os.Stderr, "  http                            Start KiCad HTTP Library API server\n")
@@ -58,6 +93,77 @@ func printUsage() {
 	fmt.Fprintf(os.Stderr, "  version                         Display version\n")
 }
 
+// parseArgs parses flags that come before or after the positional arguments,
+// as in `release ASY-001-0003 -rev v1`, and returns the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	positional := []string{}
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// releaseOptions are the flags of release and release-all
+type releaseOptions struct {
+	pmDir      *string
+	allowDirty *bool
+	commit     *bool
+	tag        *bool
+	strict     *bool
+	skipHooks  *string
+	archive    *string
+	dryRun     *bool
+	jobs       *int
+}
+
+func addReleaseFlags(fs *flag.FlagSet, config *Config) *releaseOptions {
+	return &releaseOptions{
+		pmDir:      fs.String("pmDir", config.PMDir, "specify location of partmaster CSV files"),
+		allowDirty: fs.Bool("allow-dirty", false, "allow releasing from a working tree with uncommitted changes"),
+		commit:     fs.Bool("commit", false, "commit the release directory and log"),
+		tag:        fs.Bool("tag", false, "create an annotated git tag named after the IPN"),
+		strict:     fs.Bool("strict", config.Release.Strict, "fail if BOM parts are missing from the partmaster, have no MPN, or are not checked"),
+		skipHooks:  fs.String("skip-hooks", "", "comma separated names of release script hooks not to run"),
+		archive:    fs.String("archive", "", "also write the release as an archive: zip or tar.gz"),
+		dryRun:     fs.Bool("dry-run", false, "print what the release would do, without writing anything or running hooks"),
+		jobs:       fs.Int("j", runtime.NumCPU(), "how many independent releases to run at once with -recursive or release-all"),
+	}
+}
+
+// apply sets the release options, and checks the working tree is clean if
+// needed
+func (o *releaseOptions) apply(rev string) {
+	strictRelease = *o.strict
+	if *o.skipHooks != "" {
+		skipHooks = strings.Split(*o.skipHooks, ",")
+	}
+	releaseArchive = *o.archive
+	dryRunRelease = *o.dryRun
+
+	if err := (relArchive{Format: releaseArchive}).validate(); err != nil {
+		log.Printf("Error: -archive: %v", err)
+		os.Exit(1)
+	}
+
+	if rev == "" && !*o.allowDirty && !dryRunRelease {
+		dirty, err := gitIsDirty(".")
+		if err == nil && dirty {
+			log.Println("Error: working tree has uncommitted changes. Commit them, release a revision with -rev, or use -allow-dirty")
+			os.Exit(1)
+		}
+	}
+
+	updateMsg := CheckForUpdate(version)
+	if updateMsg != "" {
+		fmt.Println(updateMsg)
+	}
+}
+
 func cmdRelease(args []string) {
 	config, err := loadConfig()
 	if err != nil {
@@ -66,21 +172,34 @@ func cmdRelease(args []string) {
 	}
 
 	fs := flag.NewFlagSet("release", flag.ExitOnError)
-	flagPMDir := fs.String("pmDir", config.PMDir, "specify location of partmaster CSV files")
-	fs.Parse(args)
+	opts := addReleaseFlags(fs, config)
+	flagRev := fs.String("rev", "", "release from a git tag or commit instead of the working tree")
+	flagRecursive := fs.Bool("recursive", false, "first release the sub-assemblies that have no release or are out of date")
+	posArgs := parseArgs(fs, args)
 
-	if fs.NArg() < 1 {
-		fmt.Fprintf(os.Stderr, "Usage: %s release <IPN> [-pmDir <dir>]\n", os.Args[0])
+	if len(posArgs) < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s release <IPN> [-pmDir <dir>] [-rev <tag|sha>] [-allow-dirty] [-commit] [-tag] [-strict] [-skip-hooks <names>] [-archive zip|tar.gz] [-dry-run] [-recursive [-j <n>]]\n", os.Args[0])
 		os.Exit(1)
 	}
 
-	releaseIPN := fs.Arg(0)
+	releaseIPN := posArgs[0]
 
-	updateMsg := CheckForUpdate(version)
-	if updateMsg != "" {
-		fmt.Println(updateMsg)
+	if *flagRecursive {
+		if *flagRev != "" {
+			log.Println("Error: -rev cannot be used with -recursive")
+			os.Exit(1)
+		}
+		pn, err := newIpn(releaseIPN)
+		if err != nil {
+			log.Fatal("Error parsing bom IPN: ", err)
+		}
+		opts.apply("")
+		releaseRecursive([]ipn{pn}, opts)
+		return
 	}
 
+	opts.apply(*flagRev)
+
 	var gLog strings.Builder
 	logMsg := func(s string) {
 		_, err := gLog.Write([]byte(s))
@@ -90,39 +209,481 @@ func cmdRelease(args []string) {
 		log.Println(s)
 	}
 
-	relPath, err := processRelease(releaseIPN, &gLog, *flagPMDir)
-	if err != nil {
-		logMsg(fmt.Sprintf("release error: %v\n", err))
+	if dryRunRelease {
+		// the plan is printed at the end, without the log prefixes
+		log.SetOutput(io.Discard)
+	}
+
+	var relPath string
+	var relErr error
+	if *flagRev != "" {
+		relPath, relErr = processReleaseAtRev(releaseIPN, &gLog, *opts.pmDir, *flagRev)
+	} else {
+		relPath, relErr = processRelease(releaseIPN, &gLog, *opts.pmDir)
+	}
+	if dryRunRelease {
+		log.SetOutput(os.Stderr)
+		fmt.Print(gLog.String())
+		if relErr != nil {
+			log.Printf("release error: %v", relErr)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if relErr != nil {
+		logMsg(fmt.Sprintf("release error: %v\n", relErr))
 	} else {
 		logMsg(fmt.Sprintf("release %v updated\n", releaseIPN))
 	}
 
 	if relPath != "" {
-		relIpn := ipn(releaseIPN)
-		_, _, _, err := relIpn.parse()
+		_, err = writeReleaseLog(releaseIPN, relPath, gLog.String())
 		if err != nil {
-			log.Fatal("Error parsing bom IPN: ", err)
+			log.Println("Error writing log file: ", err)
+		}
+	}
+
+	if relErr != nil {
+		os.Exit(1)
+	}
+	if relPath == "" {
+		return
+	}
+
+	commitRelease(releaseIPN, relPath, opts, *flagRev)
+}
+
+// writeReleaseLog writes the log of a release to CCC-NNN.log in the source dir,
+// and returns its path
+func writeReleaseLog(relPn, srcDir, relLog string) (string, error) {
+	logFilePath := releaseLogPath(relPn, srcDir)
+	return logFilePath, os.WriteFile(logFilePath, []byte(relLog), 0644)
+}
+
+// releaseLogPath returns the path of the log of a release
+func releaseLogPath(relPn, srcDir string) string {
+	return filepath.Join(srcDir, ipn(relPn).base()+".log")
+}
+
+// commitRelease commits and tags a release, as the options ask
+func commitRelease(relPn, srcDir string, opts *releaseOptions, rev string) {
+	if !*opts.commit && !*opts.tag {
+		return
+	}
+
+	msg := releaseMessage(relPn, srcDir)
+
+	if *opts.commit {
+		committed, err := gitCommitRelease(releaseCommitPaths(relPn, srcDir), msg)
+		if err != nil {
+			log.Fatal("Error committing release: ", err)
+		}
+		if committed {
+			log.Printf("release %v committed", relPn)
+		} else {
+			log.Printf("release %v unchanged, nothing to commit", relPn)
 		}
-		fn := relIpn.base() + ".log"
-		logFilePath := filepath.Join(relPath, fn)
-		err = os.WriteFile(logFilePath, []byte(gLog.String()), 0644)
+	}
+
+	if *opts.tag {
+		// without a commit of its own, the release is of the revision given
+		target := "HEAD"
+		if rev != "" && !*opts.commit {
+			target = rev
+		}
+		err := gitTagRelease(relPn, target, msg)
 		if err != nil {
-			log.Println("Error writing log file: ", err)
+			log.Fatal("Error tagging release: ", err)
 		}
+		log.Printf("release %v tagged", relPn)
+	}
+}
+
+func cmdReleaseAll(args []string) {
+	config, err := loadConfig()
+	if err != nil {
+		log.Printf("Error loading config: %v", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("release-all", flag.ExitOnError)
+	opts := addReleaseFlags(fs, config)
+	parseArgs(fs, args)
+
+	opts.apply("")
+
+	roots, skipped, err := findReleaseRoots(*opts.pmDir)
+	if err != nil {
+		log.Fatal("Error finding parts to release: ", err)
+	}
+	for _, s := range skipped {
+		fmt.Printf("skipped %v\n", s)
+	}
+
+	releaseRecursive(roots, opts)
+}
+
+// releaseRecursive releases roots, after the sub-assemblies they use that have
+// no release or are out of date. Each release is logged to its CCC-NNN.log,
+// and a line for each part is printed as it finishes. The releases are
+// committed and tagged bottom-up once all are done.
+func releaseRecursive(roots []ipn, opts *releaseOptions) {
+	plan, err := planReleases(roots)
+	if err != nil {
+		log.Fatal("Error planning releases: ", err)
+	}
+
+	release := func(pn ipn, relLog *strings.Builder) (string, error) {
+		srcDir, err := processRelease(pn.String(), relLog, *opts.pmDir)
+		if dryRunRelease {
+			dryRunPending.Store(pn, true)
+			return srcDir, err
+		}
+		if err != nil {
+			relLog.WriteString(fmt.Sprintf("release error: %v\n", err))
+		} else {
+			relLog.WriteString(fmt.Sprintf("release %v updated\n", pn))
+		}
+		if srcDir != "" {
+			_, logErr := writeReleaseLog(pn.String(), srcDir, relLog.String())
+			if logErr != nil && err == nil {
+				err = fmt.Errorf("Error writing log file: %v", logErr)
+			}
+		}
+		return srcDir, err
+	}
+
+	done := func(r releaseResult) {
+		switch {
+		case r.err != nil:
+			fmt.Printf("%v: error: %v\n", r.pn, r.err)
+		case r.reason == "":
+			fmt.Printf("%v: up to date\n", r.pn)
+		case dryRunRelease:
+			fmt.Printf("%v: would release, %v\n", r.pn, r.reason)
+		default:
+			fmt.Printf("%v: released, %v\n", r.pn, r.reason)
+		}
+		// the plan of each part, below its line
+		if dryRunRelease && r.log != "" {
+			for _, l := range strings.Split(strings.TrimRight(r.log, "\n"), "\n") {
+				fmt.Printf("  %v\n", l)
+			}
+		}
+	}
+
+	// the releases run concurrently, their logs are in their log files, or
+	// printed by done in a dry run
+	log.SetOutput(io.Discard)
+	results := runReleases(plan, *opts.jobs, release, done)
+	log.SetOutput(os.Stderr)
+
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Printf("%v of %v releases failed", failed, len(results))
+		os.Exit(1)
+	}
+
+	if dryRunRelease {
+		return
+	}
+
+	for _, r := range results {
+		if r.srcDir != "" {
+			commitRelease(r.pn.String(), r.srcDir, opts, "")
+		}
+	}
+}
+
+func cmdVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	posArgs := parseArgs(fs, args)
+
+	if len(posArgs) < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s verify <release-dir>\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	releaseDir := posArgs[0]
+
+	problems, err := verifyRelease(releaseDir)
+	if err != nil {
+		log.Printf("Error verifying %v: %v", releaseDir, err)
+		os.Exit(1)
+	}
+
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		fmt.Printf("%v does not match its manifest\n", releaseDir)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%v matches its manifest\n", releaseDir)
+}
+
+func cmdWhereUsed(args []string) {
+	fs := flag.NewFlagSet("where-used", flag.ExitOnError)
+	posArgs := parseArgs(fs, args)
+
+	if len(posArgs) < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s where-used <IPN>\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	pn, err := newIpn(posArgs[0])
+	if err != nil {
+		log.Printf("Error parsing IPN %v: %v", posArgs[0], err)
+		os.Exit(1)
+	}
+
+	idx, err := loadBomIndex()
+	if err != nil {
+		log.Printf("Error loading BOMs: %v", err)
+		os.Exit(1)
+	}
+	for _, s := range idx.skipped {
+		log.Printf("Warning: %v", s)
+	}
+
+	lines, err := idx.whereUsed(pn)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		os.Exit(1)
+	}
+
+	fmt.Print(formatWhereUsed(pn, lines))
+}
+
+func cmdDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	flagFormat := fs.String("format", "text", "output format: text, md or csv")
+	flagAll := fs.Bool("all", false, "compare the -all.csv roll-up BOMs of releases")
+	flagOutput := fs.String("out", "", "output file (default: stdout)")
+	posArgs := parseArgs(fs, args)
+
+	if len(posArgs) < 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s diff <IPN|file.csv> <IPN|file.csv> [-all] [-format text|md|csv] [-out <file>]\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	a, err := loadDiffBom(posArgs[0], *flagAll)
+	if err != nil {
+		log.Printf("Error loading %v: %v", posArgs[0], err)
+		os.Exit(1)
+	}
+
+	b, err := loadDiffBom(posArgs[1], *flagAll)
+	if err != nil {
+		log.Printf("Error loading %v: %v", posArgs[1], err)
+		os.Exit(1)
+	}
+
+	d := diffBoms(posArgs[0], a, posArgs[1], b)
+
+	var out string
+	switch *flagFormat {
+	case "text":
+		out = d.text()
+	case "md":
+		out = d.markdown()
+	case "csv":
+		out, err = d.csv()
+		if err != nil {
+			log.Printf("Error writing CSV: %v", err)
+			os.Exit(1)
+		}
+	default:
+		log.Printf("Unknown format %v, use text, md or csv", *flagFormat)
+		os.Exit(1)
+	}
+
+	if *flagOutput == "" {
+		fmt.Print(out)
+		return
+	}
+
+	err = os.WriteFile(*flagOutput, []byte(out), 0644)
+	if err != nil {
+		log.Printf("Error writing %v: %v", *flagOutput, err)
+		os.Exit(1)
+	}
+}
+
+func cmdLint(args []string) {
+	config, err := loadConfig()
+	if err != nil {
+		log.Printf("Error loading config: %v", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	flagPMDir := fs.String("pmDir", config.PMDir, "specify location of partmaster CSV files")
+	flagFormat := fs.String("format", "text", "output format: text or json")
+	parseArgs(fs, args)
+
+	if *flagPMDir == "" {
+		log.Println("Error, no partmaster directory, use -pmDir or set pmDir in gitplm.yml")
+		os.Exit(1)
+	}
+
+	c, err := loadAllCSVFiles(*flagPMDir)
+	if err != nil {
+		log.Printf("Error loading partmaster: %v", err)
+		os.Exit(1)
+	}
+
+	issues := lintPartmaster(c)
+
+	switch *flagFormat {
+	case "text":
+		for _, i := range issues {
+			fmt.Println(i)
+		}
+		if len(issues) > 0 {
+			fmt.Printf("%v problems found\n", len(issues))
+		}
+	case "json":
+		data, err := json.MarshalIndent(issues, "", "  ")
+		if err != nil {
+			log.Printf("Error encoding JSON: %v", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	default:
+		log.Printf("Unknown format %v, use text or json", *flagFormat)
+		os.Exit(1)
+	}
+
+	if len(issues) > 0 {
+		os.Exit(1)
+	}
+}
+
+func cmdNewIPN(args []string) {
+	config, err := loadConfig()
+	if err != nil {
+		log.Printf("Error loading config: %v", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("new-ipn", flag.ExitOnError)
+	flagPMDir := fs.String("pmDir", config.PMDir, "specify location of partmaster CSV files")
+	flagVariation := fs.String("variation", defaultNewVariation, "variation (VVVV) of the new IPN")
+	posArgs := parseArgs(fs, args)
+
+	if len(posArgs) < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s new-ipn <CCC> [-variation VVVV] [-pmDir <dir>]\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	if *flagPMDir == "" {
+		log.Println("Error, no partmaster directory, use -pmDir or set pmDir in gitplm.yml")
+		os.Exit(1)
+	}
+
+	category := posArgs[0]
+
+	a, err := newIpnAllocator(*flagPMDir)
+	if err != nil {
+		log.Printf("Error finding the IPNs in use: %v", err)
+		os.Exit(1)
+	}
+
+	for _, c := range a.collisions(category) {
+		log.Printf("Warning: %v", c)
+	}
+
+	pn, err := a.next(category, *flagVariation)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(pn)
+}
+
+func cmdBump(args []string) {
+	config, err := loadConfig()
+	if err != nil {
+		log.Printf("Error loading config: %v", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("bump", flag.ExitOnError)
+	flagPMDir := fs.String("pmDir", config.PMDir, "specify location of partmaster CSV files")
+	posArgs := parseArgs(fs, args)
+
+	if len(posArgs) < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s bump <IPN|CCC-NNN> [-pmDir <dir>]\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	pn, srcDir, err := bumpPart(posArgs[0], *flagPMDir, time.Now().Format("2006-01-02"), func(s string) {
+		log.Println(s)
+	})
+	if err != nil {
+		log.Printf("Error bumping %v: %v", posArgs[0], err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%v: describe the changes in %v, then release it\n", pn, filepath.Join(srcDir, "CHANGELOG.md"))
+}
+
+func cmdImportKiCad(args []string) {
+	fs := flag.NewFlagSet("import-kicad", flag.ExitOnError)
+	flagOutput := fs.String("out", "", "output file (default: CCC-NNN.csv next to CCC-NNN.yml)")
+	posArgs := parseArgs(fs, args)
+
+	if len(posArgs) < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s import-kicad <file.net|file.xml|file.kicad_sch> [-out <file>]\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	inputFile := posArgs[0]
+
+	updateMsg := CheckForUpdate(version)
+	if updateMsg != "" {
+		fmt.Println(updateMsg)
+	}
+
+	outputFile := *flagOutput
+	if outputFile == "" {
+		var err error
+		outputFile, err = kicadBomPath(filepath.Dir(inputFile))
+		if err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	err := importKiCadBom(inputFile, outputFile, func(s string) {
+		log.Print(s)
+	})
+	if err != nil {
+		log.Printf("Error importing %v: %v", inputFile, err)
+		os.Exit(1)
 	}
 }
 
 func cmdSimplify(args []string) {
 	fs := flag.NewFlagSet("simplify", flag.ExitOnError)
 	flagOutput := fs.String("out", "", "output file")
-	fs.Parse(args)
+	posArgs := parseArgs(fs, args)
 
-	if fs.NArg() < 1 {
+	if len(posArgs) < 1 {
 		fmt.Fprintf(os.Stderr, "Usage: %s simplify <file> -out <file>\n", os.Args[0])
 		os.Exit(1)
 	}
 
-	inputFile := fs.Arg(0)
+	inputFile := posArgs[0]
 
 	updateMsg := CheckForUpdate(version)
 	if updateMsg != "" {
@@ -147,7 +708,7 @@ func cmdSimplify(args []string) {
 		os.Exit(1)
 	}
 
-	err = saveCSV(*flagOutput, out)
+	err = saveSourceBomCSV(*flagOutput, out)
 	if err != nil {
 		log.Printf("Error saving CSV: %v: %v", *flagOutput, err)
 		os.Exit(1)
@@ -157,14 +718,14 @@ func cmdSimplify(args []string) {
 func cmdCombine(args []string) {
 	fs := flag.NewFlagSet("combine", flag.ExitOnError)
 	flagOutput := fs.String("out", "", "output file")
-	fs.Parse(args)
+	posArgs := parseArgs(fs, args)
 
-	if fs.NArg() < 1 {
+	if len(posArgs) < 1 {
 		fmt.Fprintf(os.Stderr, "Usage: %s combine <file> -out <file>\n", os.Args[0])
 		os.Exit(1)
 	}
 
-	inputFile := fs.Arg(0)
+	inputFile := posArgs[0]
 
 	updateMsg := CheckForUpdate(version)
 	if updateMsg != "" {
@@ -197,7 +758,7 @@ func cmdCombine(args []string) {
 		os.Exit(1)
 	}
 
-	err = saveCSV(*flagOutput, out)
+	err = saveSourceBomCSV(*flagOutput, out)
 	if err != nil {
 		log.Printf("Error saving CSV: %v: %v", *flagOutput, err)
 		os.Exit(1)
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+const manifestFile = "manifest.yml"
+
+// manifestFileEntry records a file in a release directory
+type manifestFileEntry struct {
+	Path   string `yaml:"path"`
+	Size   int64  `yaml:"size"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// manifestSubRelease records a link to the release package of a sub-assembly
+type manifestSubRelease struct {
+	IPN    string `yaml:"ipn"`
+	Link   string `yaml:"link"`
+	SHA256 string `yaml:"manifestSha256,omitempty"`
+}
+
+// manifest lists what a release contains and how it was made, so a release
+// package can later be checked for changes with `gitplm verify`.
+type manifest struct {
+	IPN           string               `yaml:"ipn"`
+	GitplmVersion string               `yaml:"gitplmVersion"`
+	SourceCommit  string               `yaml:"sourceCommit,omitempty"`
+	Partmaster    []manifestFileEntry  `yaml:"partmaster,omitempty"`
+	Files         []manifestFileEntry  `yaml:"files"`
+	SubReleases   []manifestSubRelease `yaml:"subReleases,omitempty"`
+	// Archive is the archive of the release dir, with a path relative to it
+	Archive *manifestFileEntry `yaml:"archive,omitempty"`
+}
+
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// scanReleaseDir hashes the files in a release dir, and reads the links to
+// sub-assembly releases. The manifest itself is skipped.
+func scanReleaseDir(releaseDir string) ([]manifestFileEntry, []manifestSubRelease, error) {
+	files := []manifestFileEntry{}
+	subs := []manifestSubRelease{}
+
+	// WalkDir does not follow symbolic links, including a release dir that is
+	// reached through the link of a parent release
+	root := resolvePath(releaseDir)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			sub := manifestSubRelease{IPN: rel, Link: filepath.ToSlash(link)}
+			subManifest := filepath.Join(path, manifestFile)
+			if e, _ := exists(subManifest); e {
+				sub.SHA256, _, err = hashFile(subManifest)
+				if err != nil {
+					return err
+				}
+			}
+			subs = append(subs, sub)
+		case d.IsDir(), rel == manifestFile:
+		default:
+			sum, size, err := hashFile(path)
+			if err != nil {
+				return err
+			}
+			files = append(files, manifestFileEntry{Path: rel, Size: size, SHA256: sum})
+		}
+		return nil
+	})
+
+	return files, subs, err
+}
+
+// writeManifest writes manifest.yml to the release dir. pmFiles are the
+// partmaster files the release was made with, and archivePath the archive of
+// the release dir, or "" if there is none.
+func writeManifest(relPn, releaseDir, commit string, pmFiles []string, archivePath string) error {
+	m := manifest{
+		IPN:           relPn,
+		GitplmVersion: version,
+		SourceCommit:  commit,
+	}
+
+	sort.Strings(pmFiles)
+	for _, f := range pmFiles {
+		sum, size, err := hashFile(f)
+		if err != nil {
+			return err
+		}
+		m.Partmaster = append(m.Partmaster, manifestFileEntry{
+			Path: filepath.ToSlash(f), Size: size, SHA256: sum})
+	}
+
+	var err error
+	m.Files, m.SubReleases, err = scanReleaseDir(releaseDir)
+	if err != nil {
+		return err
+	}
+
+	if archivePath != "" {
+		sum, size, err := hashFile(archivePath)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(releaseDir, archivePath)
+		if err != nil {
+			return err
+		}
+		m.Archive = &manifestFileEntry{Path: filepath.ToSlash(rel), Size: size, SHA256: sum}
+	}
+
+	data, err := yaml.Marshal(&m)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(releaseDir, manifestFile), data, 0644)
+}
+
+func loadManifest(releaseDir string) (*manifest, error) {
+	data, err := os.ReadFile(filepath.Join(releaseDir, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{}
+	err = yaml.Unmarshal(data, m)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing %v: %v", manifestFile, err)
+	}
+
+	return m, nil
+}
+
+// verifyRelease checks a release dir against its manifest and returns every
+// difference found: changed, missing, or added files, and sub-assembly links
+// that changed. The releases of sub-assemblies are verified too.
+func verifyRelease(releaseDir string) ([]string, error) {
+	return verifyReleaseDir(releaseDir, map[string]bool{})
+}
+
+func verifyReleaseDir(releaseDir string, visited map[string]bool) ([]string, error) {
+	resolved := resolvePath(releaseDir)
+	if visited[resolved] {
+		return nil, nil
+	}
+	visited[resolved] = true
+
+	m, err := loadManifest(releaseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	files, subs, err := scanReleaseDir(releaseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []string{}
+
+	found := map[string]manifestFileEntry{}
+	for _, f := range files {
+		found[f.Path] = f
+	}
+
+	for _, exp := range m.Files {
+		f, ok := found[exp.Path]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%v: missing", filepath.Join(releaseDir, exp.Path)))
+		case f.Size != exp.Size || f.SHA256 != exp.SHA256:
+			problems = append(problems, fmt.Sprintf("%v: modified", filepath.Join(releaseDir, exp.Path)))
+		}
+		delete(found, exp.Path)
+	}
+
+	added := []string{}
+	for p := range found {
+		added = append(added, p)
+	}
+	sort.Strings(added)
+	for _, p := range added {
+		problems = append(problems, fmt.Sprintf("%v: not in manifest", filepath.Join(releaseDir, p)))
+	}
+
+	if m.Archive != nil {
+		// the archive is next to the release dir, not next to a link to it
+		archivePath := filepath.Join(resolved, filepath.FromSlash(m.Archive.Path))
+		sum, size, err := hashFile(archivePath)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			problems = append(problems, fmt.Sprintf("%v: archive missing", archivePath))
+		case err != nil:
+			return nil, err
+		case size != m.Archive.Size || sum != m.Archive.SHA256:
+			problems = append(problems, fmt.Sprintf("%v: archive modified", archivePath))
+		}
+	}
+
+	foundSubs := map[string]manifestSubRelease{}
+	for _, s := range subs {
+		foundSubs[s.IPN] = s
+	}
+
+	for _, exp := range m.SubReleases {
+		s, ok := foundSubs[exp.IPN]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%v: sub-assembly link missing", filepath.Join(releaseDir, exp.IPN)))
+			continue
+		case s.Link != exp.Link:
+			problems = append(problems, fmt.Sprintf("%v: sub-assembly link changed from %v to %v",
+				filepath.Join(releaseDir, exp.IPN), exp.Link, s.Link))
+		case s.SHA256 != exp.SHA256:
+			problems = append(problems, fmt.Sprintf("%v: sub-assembly manifest changed", filepath.Join(releaseDir, exp.IPN)))
+		}
+
+		delete(foundSubs, exp.IPN)
+
+		if s.SHA256 != "" {
+			subProblems, err := verifyReleaseDir(filepath.Join(releaseDir, exp.IPN), visited)
+			if err != nil {
+				return nil, err
+			}
+			problems = append(problems, subProblems...)
+		}
+	}
+
+	for _, s := range subs {
+		if _, ok := foundSubs[s.IPN]; ok {
+			problems = append(problems, fmt.Sprintf("%v: sub-assembly link not in manifest", filepath.Join(releaseDir, s.IPN)))
+		}
+	}
+
+	return problems, nil
+}
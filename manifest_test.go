@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+
+	release(t, "PCB-019-0001", pmDir)
+	pcaDir := release(t, "PCA-019-0000", pmDir)
+	asyDir := release(t, "ASY-001-0000", pmDir)
+
+	m, err := loadManifest(asyDir)
+	if err != nil {
+		t.Fatalf("loadManifest() error: %v", err)
+	}
+
+	if m.IPN != "ASY-001-0000" || m.GitplmVersion != version {
+		t.Errorf("wrong manifest header: %+v", m)
+	}
+
+	if len(m.Partmaster) != 5 || m.Partmaster[0].Path != filepath.Join(pmDir, "asy.csv") {
+		t.Errorf("wrong partmaster files: %+v", m.Partmaster)
+	}
+
+	files := []string{}
+	for _, f := range m.Files {
+		files = append(files, f.Path)
+	}
+	expFiles := []string{
+		"ASY-001-0000-all.csv",
+		"ASY-001-0000-tree.csv",
+		"ASY-001-0000-tree.md",
+		"ASY-001-0000.csv",
+		"CHANGELOG-all.md",
+		"CHANGELOG.md",
+		"RELEASE_NOTES.md",
+	}
+	if !reflect.DeepEqual(files, expFiles) {
+		t.Errorf("manifest files = %v, want %v", files, expFiles)
+	}
+
+	if len(m.SubReleases) != 1 || m.SubReleases[0].IPN != "PCA-019-0000" ||
+		m.SubReleases[0].Link != "../elec/PCA-019-0000" || m.SubReleases[0].SHA256 == "" {
+		t.Errorf("wrong sub releases: %+v", m.SubReleases)
+	}
+
+	problems, err := verifyRelease(asyDir)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("verifyRelease() = %v, %v, want no problems", problems, err)
+	}
+
+	// alter the package and the package of its sub-assembly
+	err = os.WriteFile(filepath.Join(asyDir, "ASY-001-0000.csv"), []byte("IPN,Qty\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(asyDir, "notes.txt"), []byte("extra"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(filepath.Join(pcaDir, "PCA-019-0000.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err = verifyRelease(asyDir)
+	if err != nil {
+		t.Fatalf("verifyRelease() error: %v", err)
+	}
+
+	exp := []string{
+		filepath.Join(asyDir, "ASY-001-0000.csv") + ": modified",
+		filepath.Join(asyDir, "notes.txt") + ": not in manifest",
+		filepath.Join(asyDir, "PCA-019-0000", "PCA-019-0000.csv") + ": missing",
+	}
+	if !reflect.DeepEqual(problems, exp) {
+		t.Errorf("verifyRelease() = %v, want %v", problems, exp)
+	}
+}
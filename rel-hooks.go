@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// skipHooks are the names of the hooks not to run in releases
+var skipHooks = []string{}
+
+// relHook is a shell script run in a release. In the release script a hook is
+// either just the script, or a map with the script in run and these options.
+type relHook struct {
+	// Name identifies the hook in the log, and to skip it
+	Name string `yaml:"name"`
+	Run  string `yaml:"run"`
+	// Timeout is a duration such as 30s or 10m. The hook is stopped and the
+	// release fails if it runs longer. There is no limit if empty.
+	Timeout string `yaml:"timeout"`
+	// Dir is the working directory, relative to the source dir. The hook runs
+	// in the working directory of gitplm if empty.
+	Dir string `yaml:"dir"`
+	// Env are environment variables added to those gitplm sets
+	Env map[string]string `yaml:"env"`
+}
+
+func (h *relHook) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var run string
+	if err := unmarshal(&run); err == nil {
+		*h = relHook{Run: run}
+		return nil
+	}
+
+	type plain relHook
+	return unmarshal((*plain)(h))
+}
+
+// hookWaitDelay is how long a hook that is stopped, or has exited, may keep
+// its output open, such as through a background process
+var hookWaitDelay = 5 * time.Second
+
+// logWriter writes output to a log function, a line at a time
+type logWriter struct {
+	mu     sync.Mutex
+	prefix string
+	buf    []byte
+	log    func(string)
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.log(w.prefix + string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// flush logs a last line without a newline
+func (w *logWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.log(w.prefix + string(w.buf))
+		w.buf = nil
+	}
+}
+
+// hookEnv returns the environment variables gitplm sets for hooks
+func hookEnv(pn, srcDir, relDir, pmDir string) []string {
+	c, n, v, _ := ipn(pn).parse()
+	return []string{
+		"GITPLM_IPN=" + pn,
+		"GITPLM_CATEGORY=" + c,
+		"GITPLM_NUMBER=" + fmt.Sprintf("%0*v", ipn(pn).nWidth(), n),
+		"GITPLM_VARIATION=" + v,
+		"GITPLM_SRC_DIR=" + srcDir,
+		"GITPLM_REL_DIR=" + relDir,
+		"GITPLM_PM_DIR=" + pmDir,
+		"GITPLM_VERSION=" + version,
+	}
+}
+
+// hooks runs the hooks of the release script in order, except those named in
+// skipHooks. Their output is sent to logMsg. It stops at the first hook that
+// fails or times out.
+func (rs *relScript) hooks(data relTemplateData, pmDir string, logMsg func(string)) error {
+	// hooks may run in another dir, so they get absolute paths
+	pn, srcDir, destDir := data.IPN, data.SrcDir, data.RelDir
+	if pmDir != "" {
+		var err error
+		pmDir, err = filepath.Abs(pmDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, h := range rs.Hooks {
+		name := h.Name
+		if name == "" {
+			name = fmt.Sprintf("hook %v", i+1)
+		}
+
+		if lo.Contains(skipHooks, name) {
+			logMsg(fmt.Sprintf("%v: skipped\n", name))
+			continue
+		}
+
+		script, err := data.expand(h.Run)
+		if err != nil {
+			return fmt.Errorf("Error parsing %v: %v", name, err)
+		}
+
+		if dryRunRelease {
+			logMsg(fmt.Sprintf("%v: would run:\n%v\n", name, strings.TrimRight(script, "\n")))
+			continue
+		}
+
+		timeout := time.Duration(0)
+		if h.Timeout != "" {
+			timeout, err = time.ParseDuration(h.Timeout)
+			if err != nil {
+				return fmt.Errorf("%v: invalid timeout: %v", name, err)
+			}
+		}
+
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", script)
+		cmd.WaitDelay = hookWaitDelay
+		if h.Dir != "" {
+			cmd.Dir = h.Dir
+			if !filepath.IsAbs(h.Dir) {
+				cmd.Dir = filepath.Join(srcDir, h.Dir)
+			}
+		}
+
+		env := hookEnv(pn, srcDir, destDir, pmDir)
+		keys := lo.Keys(h.Env)
+		sort.Strings(keys)
+		for _, k := range keys {
+			env = append(env, k+"="+h.Env[k])
+		}
+		cmd.Env = append(os.Environ(), env...)
+
+		out := &logWriter{prefix: name + ": ", log: func(s string) { logMsg(s + "\n") }}
+		cmd.Stdout = out
+		cmd.Stderr = out
+
+		logMsg(fmt.Sprintf("%v: running\n", name))
+		start := time.Now()
+		err = cmd.Run()
+		cancel()
+		out.flush()
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%v timed out after %v", name, timeout)
+		}
+		if err != nil {
+			return fmt.Errorf("%v failed: %v", name, err)
+		}
+
+		logMsg(fmt.Sprintf("%v: done in %v\n", name, time.Since(start).Round(time.Millisecond)))
+	}
+
+	return nil
+}
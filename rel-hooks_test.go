@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var hooksFile = `
+hooks:
+  - echo "plain {{ .IPN }}"
+  - name: env
+    dir: sub
+    env:
+      EXTRA: x
+    run: |
+      echo "$GITPLM_CATEGORY $GITPLM_NUMBER $GITPLM_VARIATION $GITPLM_PM_DIR $EXTRA"
+      basename "$(pwd)"
+      echo "to stderr" >&2
+  - name: docs
+    run: exit 1
+`
+
+func runHooks(t *testing.T, rs relScript) ([]string, error) {
+	t.Helper()
+	srcDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := newRelTemplateData("PCA-019-0002", srcDir, filepath.Join(srcDir, "PCA-019-0002"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := []string{}
+	err = rs.hooks(data, "pm", func(s string) { log = append(log, strings.TrimSuffix(s, "\n")) })
+	return log, err
+}
+
+func TestHooks(t *testing.T) {
+	rs := relScript{}
+	if err := yaml.Unmarshal([]byte(hooksFile), &rs); err != nil {
+		t.Fatalf("error parsing yaml: %v", err)
+	}
+
+	pmAbs, err := filepath.Abs("pm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := runHooks(t, rs)
+	if err == nil || err.Error() != "docs failed: exit status 1" {
+		t.Errorf("failing hook: %v", err)
+	}
+
+	exp := []string{
+		"hook 1: running",
+		"hook 1: plain PCA-019-0002",
+		"env: running",
+		"env: PCA 019 0002 " + pmAbs + " x",
+		"env: sub",
+		"env: to stderr",
+		"docs: running",
+	}
+	got := []string{}
+	for _, l := range log {
+		if !strings.Contains(l, ": done in ") {
+			got = append(got, l)
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("log:\n%v\nwant:\n%v", strings.Join(got, "\n"), strings.Join(exp, "\n"))
+	}
+
+	skipHooks = []string{"docs", "hook 1"}
+	t.Cleanup(func() { skipHooks = []string{} })
+
+	log, err = runHooks(t, rs)
+	if err != nil {
+		t.Errorf("skipping the failing hook: %v", err)
+	}
+	if log[0] != "hook 1: skipped" || log[len(log)-1] != "docs: skipped" {
+		t.Errorf("log: %v", log)
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	hookWaitDelay = 100 * time.Millisecond
+	t.Cleanup(func() { hookWaitDelay = 5 * time.Second })
+
+	rs := relScript{Hooks: []relHook{{Name: "slow", Run: "sleep 10", Timeout: "200ms"}}}
+
+	start := time.Now()
+	_, err := runHooks(t, rs)
+	if err == nil || err.Error() != "slow timed out after 200ms" {
+		t.Errorf("timeout error: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("hook was not stopped")
+	}
+
+	rs.Hooks[0].Timeout = "soon"
+	if _, err := runHooks(t, rs); err == nil {
+		t.Error("invalid timeout did not fail")
+	}
+}
+
+func TestHookDirPaths(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	writeTree(t, dir, map[string]string{
+		"src/sub/x":                  "",
+		"src/PCA-019-0002/notes.txt": "",
+	})
+
+	rs := relScript{}
+	err := yaml.Unmarshal([]byte(`
+hooks:
+  - dir: sub
+    run: |
+      ls {{ .RelDir }}
+      ls "$GITPLM_REL_DIR"
+      test -f {{ .SrcDir }}/sub/x
+      test -f "$GITPLM_SRC_DIR/sub/x"
+`), &rs)
+	if err != nil {
+		t.Fatalf("error parsing yaml: %v", err)
+	}
+
+	// the dirs are relative to the working dir, not to the hook's dir
+	data, err := newRelTemplateData("PCA-019-0002", "src", filepath.Join("src", "PCA-019-0002"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := []string{}
+	err = rs.hooks(data, "", func(s string) { log = append(log, strings.TrimSuffix(s, "\n")) })
+	if err != nil {
+		t.Fatalf("hook with a dir: %v\n%v", err, strings.Join(log, "\n"))
+	}
+	if len(log) < 3 || log[1] != "hook 1: notes.txt" || log[2] != "hook 1: notes.txt" {
+		t.Errorf("log: %v", log)
+	}
+}
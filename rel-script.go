@@ -1,63 +1,449 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"io"
-	"log"
 	"os"
-	"os/exec"
 	"path"
+	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/otiai10/copy"
+	"github.com/samber/lo"
 )
 
 type relScript struct {
 	Description string
-	Remove      []bomLine
-	Add         []bomLine
-	Copy        []string
-	Hooks       []string
-	Required    []string
+	// Kicad is a KiCad schematic or netlist in the source dir. If set, the
+	// BOM is read from it in each release, rather than from a CSV file.
+	Kicad string
+	// Items is the BOM of an assembly declared in the release script, for
+	// assemblies without a CSV BOM, such as mechanical ones
+	Items    []relItem
+	Remove   []relMatch
+	Replace  []relReplace
+	Set      []relSet
+	Add      []bomLine
+	Copy     []relCopy
+	Hooks    []relHook
+	Required []relRequired
+	// Variants holds the BOM rules of build options, keyed by variation or
+	// by a glob of variations, such as 0001 or 01*
+	Variants map[string]relVariant
+	// Archive packs the release dir into a zip or tar.gz file next to it
+	Archive relArchive
 }
 
-func (rs *relScript) processBom(b bom) (bom, error) {
+// relVariant are BOM rules applied on top of the common ones when releasing a
+// variation
+type relVariant struct {
+	Remove  []relMatch
+	Replace []relReplace
+	Set     []relSet
+	Add     []bomLine
+}
+
+// forVariation returns the release script for variation v, with the rules of
+// the variants that match v after the common ones. Variants keyed by a glob
+// are applied before the one keyed by v itself, so it can refine them.
+func (rs *relScript) forVariation(v string) (relScript, []string, error) {
+	keys := lo.Keys(rs.Variants)
+	sort.Strings(keys)
+
+	matched := []string{}
+	for _, k := range keys {
+		ok, err := path.Match(k, v)
+		if err != nil {
+			return relScript{}, nil, fmt.Errorf("variant %v: %v", k, err)
+		}
+		if ok && k != v {
+			matched = append(matched, k)
+		}
+	}
+	if _, ok := rs.Variants[v]; ok {
+		matched = append(matched, v)
+	}
+
+	ret := *rs
+	ret.Remove = append([]relMatch{}, rs.Remove...)
+	ret.Replace = append([]relReplace{}, rs.Replace...)
+	ret.Set = append([]relSet{}, rs.Set...)
+	ret.Add = append([]bomLine{}, rs.Add...)
+	for _, k := range matched {
+		vr := rs.Variants[k]
+		ret.Remove = append(ret.Remove, vr.Remove...)
+		ret.Replace = append(ret.Replace, vr.Replace...)
+		ret.Set = append(ret.Set, vr.Set...)
+		ret.Add = append(ret.Add, vr.Add...)
+	}
+
+	return ret, matched, nil
+}
+
+// relItem is a line of a BOM declared in a release script
+type relItem struct {
+	IPN   ipn
+	Qty   float64
+	Ref   string
+	Notes string
+}
+
+// itemsBom returns the BOM declared by the items of the release script. The
+// quantity of an item defaults to the number of its reference designators, or
+// 1 if it has none. If p is not nil, parts that are not ours must be in it.
+// All problems found are returned in one error.
+func (rs *relScript) itemsBom(p partmaster) (bom, error) {
+	ret := bom{}
+	problems := []string{}
+	seen := map[ipn]bool{}
+
+	for i, it := range rs.Items {
+		problem := func(format string, args ...any) {
+			problems = append(problems, fmt.Sprintf("item %v (%v): ", i+1, it.IPN)+
+				fmt.Sprintf(format, args...))
+		}
+
+		if _, err := newIpn(it.IPN.String()); err != nil {
+			problem("not a valid IPN")
+			continue
+		}
+
+		if seen[it.IPN] {
+			problem("listed more than once")
+		}
+		seen[it.IPN] = true
+
+		ref := sortReferenceDesignators(strings.Join(refFields(it.Ref), " "))
+		refs := len(refFields(ref))
+		qty := it.Qty
+		switch {
+		case qty < 0:
+			problem("qty %v is negative", qty)
+		case qty == 0 && refs > 0:
+			qty = float64(refs)
+		case qty == 0:
+			qty = 1
+		case refs > 0 && qty != float64(refs):
+			problem("qty %v does not match the %v reference designators", qty, refs)
+		}
+
+		if ours, _ := it.IPN.isOurIPN(); !ours && p != nil {
+			if _, err := p.findPart(it.IPN); err != nil {
+				problem("not in the partmaster")
+			}
+		}
+
+		ret = append(ret, &bomLine{IPN: it.IPN, Qty: qty, Ref: ref, Notes: it.Notes})
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid items:\n%v", strings.Join(problems, "\n"))
+	}
+
+	sort.Sort(ret)
+
+	return ret, nil
+}
+
+// relMatch selects BOM lines, and optionally some of their reference
+// designators, for release script operations. A line matches if it matches
+// every field given.
+type relMatch struct {
+	CmpName string `yaml:"cmpName"`
+	IPN     ipn    `yaml:"ipn"`
+	// Ref is a list of reference designators separated by spaces or commas.
+	// Each can be a glob, such as TP*, or a range, such as R10-R20.
+	Ref string `yaml:"ref"`
+	// Footprint is a regular expression
+	Footprint string `yaml:"footprint"`
+}
+
+func (m relMatch) String() string {
+	ret := []string{}
+	for _, f := range []struct{ name, value string }{
+		{"cmpName", m.CmpName},
+		{"ipn", m.IPN.String()},
+		{"ref", m.Ref},
+		{"footprint", m.Footprint},
+	} {
+		if f.value != "" {
+			ret = append(ret, fmt.Sprintf("%v: %v", f.name, f.value))
+		}
+	}
+	return strings.Join(ret, ", ")
+}
+
+// relReplace swaps part IPN for With, on all lines of IPN, or only for the
+// reference designators in Ref, which has the same patterns as relMatch
+type relReplace struct {
+	IPN  ipn    `yaml:"ipn"`
+	With ipn    `yaml:"with"`
+	Ref  string `yaml:"ref"`
+}
+
+// relSet overrides fields of the lines that match, such as the quantity of
+// parts without reference designators like glue or labels
+type relSet struct {
+	relMatch    `yaml:",inline"`
+	Qty         *float64 `yaml:"qty"`
+	Value       string   `yaml:"value"`
+	Description string   `yaml:"description"`
+	Notes       string   `yaml:"notes"`
+}
+
+// reRefRange matches a range of reference designators, such as R10-R20
+var reRefRange = regexp.MustCompile(`^([A-Za-z_]+)(\d+)-([A-Za-z_]*)(\d+)$`)
+
+// lineMatcher is a compiled relMatch
+type lineMatcher struct {
+	relMatch
+	footprint *regexp.Regexp
+	refs      []func(string) bool
+}
+
+func (m relMatch) compile() (*lineMatcher, error) {
+	if m == (relMatch{}) {
+		return nil, errors.New("nothing to match, give a cmpName, ipn, ref, or footprint")
+	}
+
+	ret := &lineMatcher{relMatch: m}
+
+	if m.Footprint != "" {
+		re, err := regexp.Compile(m.Footprint)
+		if err != nil {
+			return nil, fmt.Errorf("footprint: %v", err)
+		}
+		ret.footprint = re
+	}
+
+	refs, err := compileRefs(m.Ref)
+	if err != nil {
+		return nil, err
+	}
+	ret.refs = refs
+
+	return ret, nil
+}
+
+// compileRefs returns a function to match each reference designator pattern
+// in refs
+func compileRefs(refs string) ([]func(string) bool, error) {
+	ret := []func(string) bool{}
+	for _, p := range refFields(refs) {
+		if g := reRefRange.FindStringSubmatch(p); g != nil {
+			prefix := g[1]
+			if g[3] != "" && g[3] != prefix {
+				return nil, fmt.Errorf("ref range %v has two prefixes", p)
+			}
+			from, _ := strconv.Atoi(g[2])
+			to, _ := strconv.Atoi(g[4])
+			if from > to {
+				return nil, fmt.Errorf("ref range %v is empty", p)
+			}
+			ret = append(ret, func(r string) bool {
+				n, err := strconv.Atoi(strings.TrimPrefix(r, prefix))
+				return err == nil && strings.HasPrefix(r, prefix) && n >= from && n <= to
+			})
+			continue
+		}
+
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("ref %v: %v", p, err)
+		}
+		ret = append(ret, func(r string) bool {
+			ok, _ := path.Match(p, r)
+			return ok
+		})
+	}
+	return ret, nil
+}
+
+// matchLine returns true if a line matches the fields other than Ref
+func (m *lineMatcher) matchLine(l *bomLine) bool {
+	return (m.CmpName == "" || l.CmpName == m.CmpName) &&
+		(m.IPN == "" || l.IPN == m.IPN) &&
+		(m.footprint == nil || m.footprint.MatchString(l.Footprint))
+}
+
+// matchRef returns true if ref matches any of the patterns of Ref
+func (m *lineMatcher) matchRef(ref string) bool {
+	return lo.SomeBy(m.refs, func(f func(string) bool) bool { return f(ref) })
+}
+
+// describeLine describes a line, or the references of it, for the log
+func describeLine(l *bomLine, refs []string) string {
+	name := l.IPN.String()
+	if name == "" {
+		name = l.CmpName
+	}
+	if len(refs) == 0 {
+		refs = refFields(l.Ref)
+	}
+	if len(refs) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%v (%v)", name, strings.Join(refs, " "))
+}
+
+// mergeLines combines the lines of part pn into one
+func mergeLines(b bom, pn ipn) bom {
+	ret := bom{}
+	var first *bomLine
+	for _, l := range b {
+		if l.IPN != pn {
+			ret = append(ret, l)
+			continue
+		}
+		if first == nil {
+			first = l
+			ret = append(ret, l)
+			continue
+		}
+		first.Qty += l.Qty
+		first.Ref = sortReferenceDesignators(first.Ref + " " + l.Ref)
+	}
+	return ret
+}
+
+// processBom applies the remove, replace, set, and add operations to a BOM, in
+// that order. Each operation logs what it matched, and fails if it matches
+// nothing, as that is a rule that no longer applies.
+func (rs *relScript) processBom(b bom, logMsg func(string)) (bom, error) {
 	ret := b
+
 	for _, r := range rs.Remove {
-		if r.CmpName != "" {
-			retM := bom{}
-			for _, l := range ret {
-				if l.CmpName != r.CmpName {
-					retM = append(retM, l)
-				}
+		m, err := r.compile()
+		if err != nil {
+			return nil, fmt.Errorf("remove %v: %v", r, err)
+		}
+
+		retM := bom{}
+		matched := []string{}
+		for _, l := range ret {
+			if !m.matchLine(l) {
+				retM = append(retM, l)
+				continue
+			}
+			if len(m.refs) == 0 {
+				matched = append(matched, describeLine(l, nil))
+				continue
+			}
+			removed := l.removeRefs(m.matchRef)
+			if len(removed) > 0 {
+				matched = append(matched, describeLine(l, removed))
+			}
+			if l.Qty > 0 {
+				retM = append(retM, l)
 			}
-			ret = retM
 		}
 
-		if r.Ref != "" {
-			retM := bom{}
-			for _, l := range ret {
-				l.removeRef(r.Ref)
-				if l.Qty > 0 {
-					retM = append(retM, l)
-				}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("remove %v matched nothing", r)
+		}
+		logMsg(fmt.Sprintf("remove %v: %v\n", r, strings.Join(matched, ", ")))
+		ret = retM
+	}
+
+	for _, r := range rs.Replace {
+		if _, err := newIpn(r.With.String()); err != nil {
+			return nil, fmt.Errorf("replace %v: with %q is not a valid IPN", r.IPN, r.With)
+		}
+		refs, err := compileRefs(r.Ref)
+		if err != nil {
+			return nil, fmt.Errorf("replace %v: %v", r.IPN, err)
+		}
+		m := &lineMatcher{relMatch: relMatch{IPN: r.IPN}, refs: refs}
+
+		retM := bom{}
+		matched := []string{}
+		for _, l := range ret {
+			if l.IPN != r.IPN {
+				retM = append(retM, l)
+				continue
+			}
+			if len(refs) == 0 {
+				matched = append(matched, describeLine(l, nil))
+				l.IPN = r.With
+				retM = append(retM, l)
+				continue
+			}
+			moved := l.removeRefs(m.matchRef)
+			if len(moved) == 0 {
+				retM = append(retM, l)
+				continue
 			}
-			ret = retM
+			matched = append(matched, describeLine(l, moved))
+			n := *l
+			n.IPN = r.With
+			n.Ref = strings.Join(moved, " ")
+			n.Qty = float64(len(moved))
+			if l.Qty > 0 {
+				retM = append(retM, l)
+			}
+			retM = append(retM, &n)
+		}
+
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("replace %v matched nothing", r.IPN)
+		}
+		logMsg(fmt.Sprintf("replace %v with %v: %v\n", r.IPN, r.With, strings.Join(matched, ", ")))
+		ret = mergeLines(retM, r.With)
+	}
+
+	for _, r := range rs.Set {
+		m, err := r.compile()
+		if err != nil {
+			return nil, fmt.Errorf("set %v: %v", r.relMatch, err)
+		}
+
+		matched := []string{}
+		for _, l := range ret {
+			if !m.matchLine(l) {
+				continue
+			}
+			if len(m.refs) > 0 && !lo.SomeBy(refFields(l.Ref), m.matchRef) {
+				continue
+			}
+			matched = append(matched, describeLine(l, nil))
+			if r.Qty != nil {
+				l.Qty = *r.Qty
+			}
+			if r.Value != "" {
+				l.Value = r.Value
+			}
+			if r.Description != "" {
+				l.Description = r.Description
+			}
+			if r.Notes != "" {
+				l.Notes = r.Notes
+			}
+		}
+
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("set %v matched nothing", r.relMatch)
 		}
+		logMsg(fmt.Sprintf("set %v: %v\n", r.relMatch, strings.Join(matched, ", ")))
 	}
 
 	for _, a := range rs.Add {
-		refs := strings.Split(a.Ref, ",")
-		a.Qty = float64(len(refs))
-		if a.Qty < 0 {
+		// references may be separated by spaces or commas
+		a.Ref = strings.Join(refFields(a.Ref), " ")
+		a.sortRefs()
+		if refs := len(refFields(a.Ref)); refs > 0 {
+			a.Qty = float64(refs)
+		} else if a.Qty <= 0 {
 			a.Qty = 1.0
 		}
 		// for some reason we need to make a copy or it
 		// will alias the last one
 		c := a
 		ret = append(ret, &c)
+		logMsg(fmt.Sprintf("add %v\n", describeLine(&c, nil)))
 	}
 
 	sort.Sort(ret)
@@ -65,8 +451,131 @@ func (rs *relScript) processBom(b bom) (bom, error) {
 	return ret, nil
 }
 
-func (rs *relScript) copy(srcDir, destDir string) error {
+// relCopy copies files or directories from the source dir to the release dir.
+// In the release script it is either just the source path, or a map with the
+// source in src and these options. Paths can use the template variables of
+// hooks.
+type relCopy struct {
+	// Src is a path, or a glob such as gerber/*.gbr, relative to the source
+	// dir
+	Src string `yaml:"src"`
+	// Dest is the path in the release dir. It defaults to Src. If Src is a
+	// glob, or Dest ends with /, Dest is a directory the matches are copied
+	// into.
+	Dest string `yaml:"dest"`
+	// Exclude are globs of files not to copy, matched against the name and
+	// the path relative to the source dir
+	Exclude []string `yaml:"exclude"`
+}
+
+func (c *relCopy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var src string
+	if err := unmarshal(&src); err == nil {
+		*c = relCopy{Src: src}
+		return nil
+	}
+
+	type plain relCopy
+	return unmarshal((*plain)(c))
+}
+
+// copyExcludeDefault are files that are never copied to a release: editor
+// backups, and KiCad lock and autosave files
+var copyExcludeDefault = []string{"*~", "*.bak", "*.lck", "~*.lck", "#*#", "_autosave-*"}
+
+// relTemplateData is the data for the templates in hooks, copy, and required
+// rules
+type relTemplateData struct {
+	SrcDir string
+	RelDir string
+	IPN    string
+}
+
+// newRelTemplateData returns the template data of the release of pn. The dirs
+// are absolute, as hooks may run in another dir, so a template expands the same
+// in every rule.
+func newRelTemplateData(pn, srcDir, relDir string) (relTemplateData, error) {
+	srcDir, err := filepath.Abs(srcDir)
+	if err != nil {
+		return relTemplateData{}, err
+	}
+	relDir, err = filepath.Abs(relDir)
+	if err != nil {
+		return relTemplateData{}, err
+	}
+	return relTemplateData{SrcDir: srcDir, RelDir: relDir, IPN: pn}, nil
+}
+
+// inDir returns p joined to dir, or p itself if it is absolute, as a template
+// such as {{ .RelDir }} expands to
+func inDir(dir, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+func (d relTemplateData) expand(s string) (string, error) {
+	t, err := template.New("relScript").Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	err = t.Execute(&out, d)
+	return out.String(), err
+}
+
+// copy copies the files of the copy rules of the release script from the
+// source dir to the release dir of data
+func (rs *relScript) copy(data relTemplateData, logMsg func(string)) error {
+	srcDir, destDir := data.SrcDir, data.RelDir
+
 	for _, c := range rs.Copy {
+		src, err := data.expand(c.Src)
+		if err != nil {
+			return fmt.Errorf("copy %v: %v", c.Src, err)
+		}
+		dest, err := data.expand(c.Dest)
+		if err != nil {
+			return fmt.Errorf("copy %v: dest: %v", c.Src, err)
+		}
+		exclude := append([]string{}, copyExcludeDefault...)
+		for _, e := range c.Exclude {
+			e, err := data.expand(e)
+			if err != nil {
+				return fmt.Errorf("copy %v: exclude: %v", c.Src, err)
+			}
+			if _, err := filepath.Match(e, ""); err != nil {
+				return fmt.Errorf("copy %v: exclude %v: %v", c.Src, e, err)
+			}
+			exclude = append(exclude, e)
+		}
+
+		excluded := func(p string) bool {
+			rel, err := filepath.Rel(srcDir, p)
+			if err != nil {
+				rel = p
+			}
+			return lo.SomeBy(exclude, func(e string) bool {
+				name, _ := filepath.Match(e, filepath.Base(p))
+				full, _ := filepath.Match(e, rel)
+				return name || full
+			})
+		}
+
+		matches, err := filepath.Glob(inDir(srcDir, src))
+		if err != nil {
+			return fmt.Errorf("copy %v: %v", src, err)
+		}
+		matches = lo.Reject(matches, func(m string, _ int) bool { return excluded(m) })
+		if len(matches) == 0 {
+			return fmt.Errorf("copy %v matched nothing", src)
+		}
+
+		// the matches go into dest, rather than replace it
+		intoDir := strings.ContainsAny(src, "*?[") || strings.HasSuffix(dest, "/")
+
 		opts := copy.Options{
 			OnSymlink: func(src string) copy.SymlinkAction {
 				return copy.Deep
@@ -74,92 +583,182 @@ func (rs *relScript) copy(srcDir, destDir string) error {
 			OnDirExists: func(src, dest string) copy.DirExistsAction {
 				return copy.Replace
 			},
-		}
-		srcPath := path.Join(srcDir, c)
-		destPath := path.Join(destDir, c)
-		err := copy.Copy(srcPath, destPath, opts)
-		if err != nil {
-			return err
+			Skip: func(_ os.FileInfo, src, _ string) (bool, error) {
+				return excluded(src), nil
+			},
 		}
 
-		log.Printf("%v copied to release dir\n", c)
+		for _, m := range matches {
+			rel, err := filepath.Rel(srcDir, m)
+			if err != nil {
+				return err
+			}
+
+			target := rel
+			switch {
+			case intoDir && dest != "":
+				target = filepath.Join(dest, filepath.Base(m))
+			case dest != "":
+				target = dest
+			}
+			targetPath := inDir(destDir, target)
+			if filepath.IsAbs(target) {
+				target, err = filepath.Rel(destDir, target)
+				if err != nil {
+					return err
+				}
+			}
+
+			if !dryRunRelease {
+				err = copy.Copy(m, targetPath, opts)
+				if err != nil {
+					return err
+				}
+			}
+
+			switch {
+			case dryRunRelease && target == rel:
+				logMsg(fmt.Sprintf("Would copy %v to release dir\n", rel))
+			case dryRunRelease:
+				logMsg(fmt.Sprintf("Would copy %v to release dir as %v\n", rel, target))
+			case target == rel:
+				logMsg(fmt.Sprintf("%v copied to release dir\n", rel))
+			default:
+				logMsg(fmt.Sprintf("%v copied to release dir as %v\n", rel, target))
+			}
+		}
 	}
 
 	return nil
 }
 
-func (rs *relScript) hooks(pn string, srcDir, destDir string) error {
-	data := struct {
-		SrcDir string
-		RelDir string
-		IPN    string
-	}{
-		SrcDir: srcDir,
-		RelDir: destDir,
-		IPN:    pn,
+// relRequired is a file that must be in the release dir, typically one made
+// by hand, such as an export from KiCad. In the release script it is either
+// just the path, or a map with the path in path and these options. The path
+// can use the template variables of hooks.
+type relRequired struct {
+	// Path is a path, or a glob such as *_ibom.html, relative to the release
+	// dir. Every file it matches is checked.
+	Path string `yaml:"path"`
+	// MinSize is the smallest size of the file, in bytes, or with a k, M, or G
+	// suffix, such as 10k
+	MinSize string `yaml:"minSize"`
+	// Fresh requires the file to be newer than the source BOM
+	Fresh bool `yaml:"fresh"`
+	// Absent requires that no file matches, such as for a leftover export
+	Absent bool `yaml:"absent"`
+}
+
+func (r *relRequired) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var p string
+	if err := unmarshal(&p); err == nil {
+		*r = relRequired{Path: p}
+		return nil
 	}
 
-	for _, h := range rs.Hooks {
-		t, err := template.New("hook").Parse(h)
-		if err != nil {
-			return fmt.Errorf("Error parsing hook: %v: %v", h, err)
-		}
+	type plain relRequired
+	return unmarshal((*plain)(r))
+}
 
-		var out strings.Builder
+func (r relRequired) String() string {
+	opts := []string{}
+	if r.MinSize != "" {
+		opts = append(opts, "at least "+r.MinSize)
+	}
+	if r.Fresh {
+		opts = append(opts, "newer than the source BOM")
+	}
+	if r.Absent {
+		opts = append(opts, "must not exist")
+	}
+	if len(opts) == 0 {
+		return r.Path
+	}
+	return fmt.Sprintf("%v (%v)", r.Path, strings.Join(opts, ", "))
+}
 
-		err = t.Execute(&out, data)
-		if err != nil {
-			return fmt.Errorf("Error parsing hook: %v: %v", h, err)
+// parseSize parses a size in bytes, such as 512, 10k, or 2M
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	num := strings.TrimSpace(s)
+	if num != "" {
+		switch num[len(num)-1] {
+		case 'k', 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			num = num[:len(num)-1]
 		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %v", s)
+	}
+	return n * mult, nil
+}
 
-		cmd := exec.Command("/bin/sh", "-c", out.String())
+// required checks the required files of the release script in the release
+// dir of data. Files with fresh must be newer than sourceTime, the time the
+// source BOM was changed. Every file that is missing, too small, stale, or must
+// not be there is listed in the error.
+func (rs *relScript) required(data relTemplateData, sourceTime time.Time) error {
+	destDir := data.RelDir
+	problems := []string{}
 
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			log.Fatal(err)
-		}
-		stderr, err := cmd.StderrPipe()
+	for _, r := range rs.Required {
+		pattern, err := data.expand(r.Path)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("Error parsing required %v: %v", r.Path, err)
 		}
 
-		// Start the command
-		if err := cmd.Start(); err != nil {
-			log.Fatal(err)
+		minSize := int64(0)
+		if r.MinSize != "" {
+			minSize, err = parseSize(r.MinSize)
+			if err != nil {
+				return fmt.Errorf("required %v: %v", pattern, err)
+			}
 		}
 
-		// Copy the command's stdout and stderr to the Go program's stdout
-		go func() {
-			_, _ = io.Copy(os.Stdout, stdout)
-		}()
-
-		go func() {
-			_, _ = io.Copy(os.Stderr, stderr)
-		}()
+		matches, err := filepath.Glob(inDir(destDir, pattern))
+		if err != nil {
+			return fmt.Errorf("required %v: %v", pattern, err)
+		}
 
-		// Wait for the command to exit
-		if err := cmd.Wait(); err != nil {
-			log.Println("Error running hook: ", err)
-			log.Println("Hook contents: ")
-			fmt.Print(out.String())
-			return err
+		if r.Absent {
+			for _, m := range matches {
+				problems = append(problems, fmt.Sprintf("%v: must not exist, please remove it", m))
+			}
+			continue
 		}
-	}
-	return nil
-}
 
-func (rs *relScript) required(destDir string) error {
-	for _, r := range rs.Required {
-		p := path.Join(destDir, r)
-		e, err := exists(p)
-		if err != nil {
-			return fmt.Errorf("Error looking for required file: %v: %v", p, err)
+		if len(matches) == 0 {
+			problems = append(problems, fmt.Sprintf("%v: does not exist, please generate it",
+				inDir(destDir, pattern)))
+			continue
 		}
 
-		if !e {
-			return fmt.Errorf("Required file does not exist, please generate it: %v", p)
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if err != nil {
+				return fmt.Errorf("Error looking for required file: %v: %v", m, err)
+			}
+			if !info.IsDir() && info.Size() < minSize {
+				problems = append(problems, fmt.Sprintf("%v: %v bytes, smaller than %v, please regenerate it",
+					m, info.Size(), r.MinSize))
+			}
+			if r.Fresh && info.ModTime().Before(sourceTime) {
+				problems = append(problems, fmt.Sprintf("%v: older than the source BOM, please regenerate it", m))
+			}
 		}
 	}
 
+	if len(problems) > 0 {
+		return fmt.Errorf("required files:\n%v", strings.Join(problems, "\n"))
+	}
+
 	return nil
 }
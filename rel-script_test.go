@@ -2,8 +2,13 @@ package main
 
 import (
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gocarina/gocsv"
 	"gopkg.in/yaml.v2"
@@ -58,7 +63,7 @@ func TestRelScript(t *testing.T) {
 		t.Errorf("error parsing yaml: %v", err)
 	}
 
-	bModified, err := rs.processBom(bIn)
+	bModified, err := rs.processBom(bIn, func(s string) { t.Log(s) })
 	if err != nil {
 		t.Errorf("error processing bom: %v", err)
 	}
@@ -69,3 +74,374 @@ func TestRelScript(t *testing.T) {
 		t.Error("bExp not the same as bModified")
 	}
 }
+
+var itemsFile = `
+description: enclosure
+items:
+ - ipn: SCR-002-0002
+   ref: S2, S1
+   notes: torque to 0.5 Nm
+ - ipn: CAS-001-0001
+ - ipn: PCA-019-0000
+   qty: 2
+`
+
+func TestItemsBom(t *testing.T) {
+	rs := relScript{}
+	err := yaml.Unmarshal([]byte(itemsFile), &rs)
+	if err != nil {
+		t.Fatalf("error parsing yaml: %v", err)
+	}
+
+	p := partmaster{
+		{IPN: "SCR-002-0002"},
+		{IPN: "CAS-001-0001"},
+	}
+
+	b, err := rs.itemsBom(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := bom{
+		{IPN: "CAS-001-0001", Qty: 1},
+		{IPN: "PCA-019-0000", Qty: 2},
+		{IPN: "SCR-002-0002", Qty: 2, Ref: "S1 S2", Notes: "torque to 0.5 Nm"},
+	}
+	if !reflect.DeepEqual(b, exp) {
+		t.Errorf("items BOM:%v\nwant:%v", b, exp)
+	}
+
+	rs.Items = append(rs.Items,
+		relItem{IPN: "SCR-002-0002"},
+		relItem{IPN: "RES-001-1002", Qty: 3, Ref: "R1 R2"},
+		relItem{IPN: "RES-1"},
+	)
+	_, err = rs.itemsBom(p)
+	expErr := `invalid items:
+item 4 (SCR-002-0002): listed more than once
+item 5 (RES-001-1002): qty 3 does not match the 2 reference designators
+item 5 (RES-001-1002): not in the partmaster
+item 6 (RES-1): not a valid IPN`
+	if err == nil || err.Error() != expErr {
+		t.Errorf("error:\n%v\nwant:\n%v", err, expErr)
+	}
+}
+
+var opsFile = `
+remove:
+ - ref: TP*
+ - ref: R10-R12
+ - ipn: DIO-023-0023
+   ref: D13
+ - footprint: ^MountingHole
+replace:
+ - ipn: RES-006-0232
+   with: RES-006-0233
+   ref: R2
+ - ipn: CAP-000-1001
+   with: CAP-000-1002
+set:
+ - ipn: GLU-001-0001
+   qty: 0.5
+   notes: per board
+`
+
+var opsBomIn = `
+Ref,Qty,Value,Cmp name,Footprint,Description,Vendor,IPN,Datasheet
+TP4 TP5,2,,Test point 2,,,,,
+R1 R2 R3,3,,100K,,,,RES-006-0232,
+R10 R11 R12 R13,4,,10M,,,,RES-008-1005,
+D1 D13,2,,diode,,,,DIO-023-0023,
+C1,1,,100n,,,,CAP-000-1001,
+H1 H2,2,,hole,MountingHole:M3,,,,
+,1,,glue,,,,GLU-001-0001,
+`
+
+var opsBomExp = `
+Ref,Qty,Value,Cmp name,Footprint,Description,Vendor,IPN,Datasheet,Notes
+C1,1,,100n,,,,CAP-000-1002,,
+D1,1,,diode,,,,DIO-023-0023,,
+,0.5,,glue,,,,GLU-001-0001,,per board
+R1 R3,2,,100K,,,,RES-006-0232,,
+R2,1,,100K,,,,RES-006-0233,,
+R13,1,,10M,,,,RES-008-1005,,
+`
+
+func TestRelScriptOperations(t *testing.T) {
+	initCSV()
+	bIn := bom{}
+	if err := gocsv.UnmarshalBytes([]byte(opsBomIn), &bIn); err != nil {
+		t.Fatal(err)
+	}
+	bExp := bom{}
+	if err := gocsv.UnmarshalBytes([]byte(opsBomExp), &bExp); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := relScript{}
+	if err := yaml.Unmarshal([]byte(opsFile), &rs); err != nil {
+		t.Fatalf("error parsing yaml: %v", err)
+	}
+
+	log := []string{}
+	b, err := rs.processBom(bIn, func(s string) { log = append(log, s) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(b, bExp) {
+		t.Errorf("processed BOM:%v\nwant:%v", b, bExp)
+	}
+
+	expLog := []string{
+		"remove ref: TP*: Test point 2 (TP4 TP5)\n",
+		"remove ref: R10-R12: RES-008-1005 (R10 R11 R12)\n",
+		"remove ipn: DIO-023-0023, ref: D13: DIO-023-0023 (D13)\n",
+		"remove footprint: ^MountingHole: hole (H1 H2)\n",
+		"replace RES-006-0232 with RES-006-0233: RES-006-0232 (R2)\n",
+		"replace CAP-000-1001 with CAP-000-1002: CAP-000-1001 (C1)\n",
+		"set ipn: GLU-001-0001: GLU-001-0001\n",
+	}
+	if !reflect.DeepEqual(log, expLog) {
+		t.Errorf("log:\n%v\nwant:\n%v", strings.Join(log, ""), strings.Join(expLog, ""))
+	}
+
+	// a rule that matches nothing is stale
+	bIn = bom{}
+	if err := gocsv.UnmarshalBytes([]byte(opsBomIn), &bIn); err != nil {
+		t.Fatal(err)
+	}
+	rs.Remove = append(rs.Remove, relMatch{Ref: "U1"})
+	_, err = rs.processBom(bIn, func(string) {})
+	if err == nil || err.Error() != "remove ref: U1 matched nothing" {
+		t.Errorf("stale rule: %v", err)
+	}
+}
+
+var variantsFile = `
+remove:
+ - ref: TP*
+variants:
+  0001:
+    remove:
+     - ref: R2
+  0*:
+    add:
+     - ipn: SCR-002-0002
+       ref: S1
+  "1*":
+    set:
+     - ipn: RES-006-0232
+       qty: 0
+`
+
+func TestRelScriptVariants(t *testing.T) {
+	rs := relScript{}
+	if err := yaml.Unmarshal([]byte(variantsFile), &rs); err != nil {
+		t.Fatalf("error parsing yaml: %v", err)
+	}
+
+	tests := []struct {
+		v        string
+		variants []string
+		remove   int
+		add      int
+		set      int
+	}{
+		{"0001", []string{"0*", "0001"}, 2, 1, 0},
+		{"0002", []string{"0*"}, 1, 1, 0},
+		{"1000", []string{"1*"}, 1, 0, 1},
+		{"A000", []string{}, 1, 0, 0},
+	}
+
+	for _, test := range tests {
+		v, variants, err := rs.forVariation(test.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(variants, test.variants) || len(v.Remove) != test.remove ||
+			len(v.Add) != test.add || len(v.Set) != test.set {
+			t.Errorf("%v: variants %v, %v remove, %v add, %v set", test.v, variants,
+				len(v.Remove), len(v.Add), len(v.Set))
+		}
+	}
+
+	// the common rules are not changed
+	if len(rs.Remove) != 1 || len(rs.Add) != 0 {
+		t.Errorf("common rules changed: %+v", rs)
+	}
+
+	rs.Variants["[0"] = relVariant{}
+	if _, _, err := rs.forVariation("0001"); err == nil {
+		t.Error("invalid glob did not fail")
+	}
+}
+
+var copyFile = `
+copy:
+ - gerber/*.gbr
+ - src: mfg/assembly.pdf
+   dest: "{{ .IPN }}-assembly.pdf"
+ - src: docs
+   exclude: [drafts]
+ - src: gerber/*
+   dest: fab/
+   exclude: ["*.txt"]
+`
+
+func TestRelScriptCopy(t *testing.T) {
+	srcDir := t.TempDir()
+	relDir := filepath.Join(srcDir, "PCA-019-0002")
+	writeTree(t, srcDir, map[string]string{
+		"gerber/a.gbr":      "a",
+		"gerber/b.gbr":      "b",
+		"gerber/a.gbr~":     "backup",
+		"gerber/pcb.lck":    "lock",
+		"gerber/notes.txt":  "notes",
+		"mfg/assembly.pdf":  "pdf",
+		"docs/x.md":         "x",
+		"docs/x.md.bak":     "backup",
+		"docs/drafts/y.md":  "y",
+		"PCA-019-0002/keep": "",
+	})
+
+	rs := relScript{}
+	if err := yaml.Unmarshal([]byte(copyFile), &rs); err != nil {
+		t.Fatalf("error parsing yaml: %v", err)
+	}
+
+	data, err := newRelTemplateData("PCA-019-0002", srcDir, relDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := []string{}
+	err = rs.copy(data, func(s string) { log = append(log, s) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	err = filepath.WalkDir(relDir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(relDir, p)
+			got = append(got, rel)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"PCA-019-0002-assembly.pdf",
+		"docs/x.md",
+		"fab/a.gbr",
+		"fab/b.gbr",
+		"gerber/a.gbr",
+		"gerber/b.gbr",
+		"keep",
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("release dir has %v, want %v", got, exp)
+	}
+
+	if log[2] != "mfg/assembly.pdf copied to release dir as PCA-019-0002-assembly.pdf\n" {
+		t.Errorf("log: %v", log)
+	}
+
+	rs.Copy = []relCopy{{Src: "gerber/*.pdf"}}
+	err = rs.copy(data, func(string) {})
+	if err == nil || err.Error() != "copy gerber/*.pdf matched nothing" {
+		t.Errorf("copy that matches nothing: %v", err)
+	}
+
+	// the dirs expand as in hooks, to absolute paths
+	rs.Copy = []relCopy{{Src: "{{ .SrcDir }}/mfg/assembly.pdf", Dest: "{{ .RelDir }}/mfg/{{ .IPN }}.pdf"}}
+	log = []string{}
+	err = rs.copy(data, func(s string) { log = append(log, s) })
+	if err != nil {
+		t.Fatalf("copy with templated dirs: %v", err)
+	}
+	if e, _ := exists(filepath.Join(relDir, "mfg", "PCA-019-0002.pdf")); !e {
+		t.Errorf("copy with templated dirs did not copy into the release dir")
+	}
+	if len(log) != 1 || log[0] != "mfg/assembly.pdf copied to release dir as mfg/PCA-019-0002.pdf\n" {
+		t.Errorf("log: %v", log)
+	}
+}
+
+var requiredFile = `
+required:
+ - PCA-019-0002.csv
+ - path: "{{ .IPN }}_ibom.html"
+   minSize: 1k
+   fresh: true
+ - path: "*.step"
+   fresh: true
+ - gerber/*.gbr
+ - path: "*.pdf"
+ - path: "*-old.*"
+   absent: true
+`
+
+func TestRelScriptRequired(t *testing.T) {
+	srcDir := t.TempDir()
+	relDir := filepath.Join(srcDir, "PCA-019-0002")
+	writeTree(t, relDir, map[string]string{
+		"PCA-019-0002.csv":        "IPN,Qty\n",
+		"PCA-019-0002_ibom.html":  strings.Repeat("x", 2000),
+		"PCA-019-0002.step":       "step",
+		"PCA-019-0002-old.step":   "step",
+		"gerber/PCA-019-0002.gbr": "gerber",
+	})
+
+	rs := relScript{}
+	if err := yaml.Unmarshal([]byte(requiredFile), &rs); err != nil {
+		t.Fatalf("error parsing yaml: %v", err)
+	}
+
+	old := time.Now().Add(-time.Hour)
+	for _, f := range []string{"PCA-019-0002_ibom.html", "PCA-019-0002-old.step"} {
+		if err := os.Chtimes(filepath.Join(relDir, f), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := newRelTemplateData("PCA-019-0002", srcDir, relDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rs.required(data, old.Add(-time.Minute))
+	exp := "required files:\n" +
+		filepath.Join(relDir, "*.pdf") + ": does not exist, please generate it\n" +
+		filepath.Join(relDir, "PCA-019-0002-old.step") + ": must not exist, please remove it"
+	if err == nil || err.Error() != exp {
+		t.Errorf("required() error:\n%v\nwant\n%v", err, exp)
+	}
+
+	// the source BOM changed after the files were exported
+	err = rs.required(data, old.Add(time.Minute))
+	exp = "required files:\n" +
+		filepath.Join(relDir, "PCA-019-0002_ibom.html") + ": older than the source BOM, please regenerate it\n" +
+		filepath.Join(relDir, "PCA-019-0002-old.step") + ": older than the source BOM, please regenerate it\n" +
+		filepath.Join(relDir, "*.pdf") + ": does not exist, please generate it\n" +
+		filepath.Join(relDir, "PCA-019-0002-old.step") + ": must not exist, please remove it"
+	if err == nil || err.Error() != exp {
+		t.Errorf("required() error:\n%v\nwant\n%v", err, exp)
+	}
+
+	rs.Required = []relRequired{{Path: "PCA-019-0002_ibom.html", MinSize: "2k"}}
+	err = rs.required(data, old)
+	exp = "required files:\n" +
+		filepath.Join(relDir, "PCA-019-0002_ibom.html") + ": 2000 bytes, smaller than 2k, please regenerate it"
+	if err == nil || err.Error() != exp {
+		t.Errorf("required() error:\n%v\nwant\n%v", err, exp)
+	}
+
+	rs.Required = []relRequired{{Path: "*.csv", MinSize: "big"}}
+	err = rs.required(data, old)
+	if err == nil || err.Error() != "required *.csv: invalid size: big" {
+		t.Errorf("invalid size: %v", err)
+	}
+}
@@ -9,101 +9,96 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
+// strictRelease makes a release fail if a BOM has a part that is not in the
+// partmaster, a purchased part without an MPN, or a part not checked
+var strictRelease = false
+
+// dryRunRelease makes a release log what it would do, without writing
+// anything or running hooks
+var dryRunRelease = false
+
+// dryRunPending holds the parts a recursive dry run would release before the
+// parts that use them. Their releases may not exist yet, or be out of date, so
+// a dry run of a part that uses them does not read them.
+var dryRunPending sync.Map
+
 func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string, error) {
 	relIpn := ipn(relPn)
-	_, _, v, err := relIpn.parse()
+	_, _, _, err := relIpn.parse()
 	if err != nil {
 		return "", fmt.Errorf("error parsing bom %v IPN : %v", relPn, err)
 	}
 
-	relPnBase := relIpn.base()
-	relPnBaseWithVar := fmt.Sprintf("%v-%v", relPnBase, v[:2]) // First two characters of variation
-
-	bomFile := relPnBase + ".csv"
-	bomFileWithVar := relPnBaseWithVar + ".csv"
-	bomFileGenerated := relPn + ".csv"
-	ymlFile := relPnBase + ".yml"
-	ymlFileWithVar := relPnBaseWithVar + ".yml"
-	bomExists := false
-	ymlExists := false
-	sourceDir := ""
-
-	// Try to find BOM file - first try CCC-NNN.csv, then CCC-NNN-VV.csv
-	bomFilePath, err := findFile(bomFile)
-	if err == nil {
-		bomExists = true
-		sourceDir = filepath.Dir(bomFilePath)
-	} else {
-		// Try with variation pattern
-		bomFilePath, err = findFile(bomFileWithVar)
-		if err == nil {
-			bomExists = true
-			sourceDir = filepath.Dir(bomFilePath)
+	logErr := func(s string) {
+		_, err := relLog.Write([]byte(s))
+		if err != nil {
+			log.Println("Error writing to relLog: ", err)
 		}
+		log.Println(s)
 	}
 
-	// Try to find YML file - first try CCC-NNN.yml, then CCC-NNN-VV.yml
-	ymlFilePath, err := findFile(ymlFile)
-	if err == nil {
-		ymlExists = true
-		sourceDir = filepath.Dir(ymlFilePath)
-	} else {
-		// Try with variation pattern
-		ymlFilePath, err = findFile(ymlFileWithVar)
-		if err == nil {
-			ymlExists = true
-			sourceDir = filepath.Dir(ymlFilePath)
+	// plan logs what is only of interest in a dry run
+	plan := func(s string) {
+		if dryRunRelease {
+			logErr(s)
 		}
 	}
+	plan(fmt.Sprintf("Dry run of release %v, nothing is written\n", relPn))
 
-	if !ymlExists && !bomExists {
-		return "", errors.New("Could not find BOM or YML file for release IPN")
+	// record what the release was made from
+	commit, err := gitSourceCommit(".")
+	if err == nil {
+		logErr(fmt.Sprintf("Source commit: %v\n", commit))
+	} else {
+		commit = ""
 	}
 
-	if bomExists && ymlExists {
-		bomDir := filepath.Dir(bomFilePath)
-		ymlDir := filepath.Dir(ymlFilePath)
+	bomFileGenerated := relPn + ".csv"
 
-		if bomDir != ymlDir {
-			return "", fmt.Errorf("BOM and YML files should be in the same directory: %v %v", bomFilePath, ymlFilePath)
-		}
+	bomFilePath, ymlFilePath, err := findReleaseSource(relPn)
+	if err != nil {
+		return "", err
+	}
+	bomExists := bomFilePath != ""
+	ymlExists := ymlFilePath != ""
+	sourceDir := filepath.Dir(ymlFilePath)
+	if !ymlExists {
+		sourceDir = filepath.Dir(bomFilePath)
+	}
+	plan(fmt.Sprintf("Source dir: %v\n", sourceDir))
+	if bomExists {
+		plan(fmt.Sprintf("BOM: %v\n", bomFilePath))
+	}
+	if ymlExists {
+		plan(fmt.Sprintf("Release script: %v\n", ymlFilePath))
 	}
 
-	// Create output release dir
-	releaseDir := filepath.Join(sourceDir, relPn)
-
-	dirExists, err := exists(releaseDir)
+	changes, err := releaseChangelogSection(sourceDir, relPn)
 	if err != nil {
-		return sourceDir, err
+		return sourceDir, fmt.Errorf("Error reading CHANGELOG.md: %v", err)
 	}
-
-	if !dirExists {
-		err = os.Mkdir(releaseDir, 0755)
-		if err != nil {
-			return sourceDir, err
-		}
+	if changes == nil {
+		return sourceDir, fmt.Errorf("%v has no entry for %v, add a \"## [%v]\" section",
+			filepath.Join(sourceDir, "CHANGELOG.md"), relPn, relPn)
 	}
 
+	releaseDir := filepath.Join(sourceDir, relPn)
 	bomFileWritePath := filepath.Join(releaseDir, bomFileGenerated)
 
-	logErr := func(s string) {
-		_, err := relLog.Write([]byte(s))
-		if err != nil {
-			log.Println("Error writing to relLog: ", err)
-		}
-		log.Println(s)
-	}
-
 	p := partmaster{}
+	pmFiles := []string{}
 	if pmDir != "" {
 		p, err = loadPartmasterFromDir(pmDir)
 		if err != nil {
 			return sourceDir, fmt.Errorf("Error loading partmaster from directory %s: %v", pmDir, err)
 		}
+		pmFiles, _ = filepath.Glob(filepath.Join(pmDir, "*.csv"))
 	} else {
 		partmasterPath, err := findFile("partmaster.csv")
 		if err != nil {
@@ -114,44 +109,240 @@ func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string
 		if err != nil {
 			return sourceDir, err
 		}
+		pmFiles = append(pmFiles, partmasterPath)
+	}
+	plan(fmt.Sprintf("Partmaster: %v\n", strings.Join(pmFiles, ", ")))
+
+	rs := relScript{}
+
+	if ymlExists {
+		ymlBytes, err := os.ReadFile(ymlFilePath)
+		if err != nil {
+			return sourceDir, fmt.Errorf("Error loading yml file: %v", err)
+		}
+
+		err = yaml.Unmarshal(ymlBytes, &rs)
+		if err != nil {
+			return sourceDir, fmt.Errorf("Error parsing yml: %v", err)
+		}
+
+		// add the BOM rules of the build option being released
+		_, _, v, _ := relIpn.parse()
+		var variants []string
+		rs, variants, err = rs.forVariation(v)
+		if err != nil {
+			return sourceDir, fmt.Errorf("Error in %v: %v", ymlFilePath, err)
+		}
+		if len(variants) > 0 {
+			logErr(fmt.Sprintf("Variants: %v\n", strings.Join(variants, ", ")))
+		}
+	}
+
+	// the time the source BOM was changed, which required files made by
+	// hand must be newer than. With kicad, the BOM is read from the KiCad
+	// design.
+	sourceTime := time.Time{}
+	sourcePath := bomFilePath
+	switch {
+	case rs.Kicad != "":
+		sourcePath = filepath.Join(sourceDir, rs.Kicad)
+	case !bomExists:
+		sourcePath = ymlFilePath
+	}
+	if info, err := os.Stat(sourcePath); err == nil {
+		sourceTime = info.ModTime()
 	}
 
 	b := bom{}
 
-	if bomExists {
+	// read the BOM from the KiCad design. The source CSV is left as it is,
+	// so the release matches the commit it records.
+	if rs.Kicad != "" {
+		kicadPath := filepath.Join(sourceDir, rs.Kicad)
+		b, err = loadKiCadBom(kicadPath, logErr)
+		if err != nil {
+			return sourceDir, fmt.Errorf("Error reading KiCad BOM: %v", err)
+		}
+		logErr(fmt.Sprintf("%v BOM lines read from %v\n", len(b), kicadPath))
+		if bomExists {
+			logErr(fmt.Sprintf("%v is not used, the BOM is read from %v\n", bomFilePath, rs.Kicad))
+		}
+		bomExists = true
+	} else if bomExists {
 		err = loadCSV(bomFilePath, &b)
 		if err != nil {
 			return sourceDir, err
 		}
 	}
 
-	if ymlExists {
-		ymlBytes, err := os.ReadFile(ymlFilePath)
+	// the BOM is declared in the release script
+	if len(rs.Items) > 0 {
+		if bomExists {
+			src := bomFilePath
+			if rs.Kicad != "" {
+				src = rs.Kicad
+			}
+			return sourceDir, fmt.Errorf("%v has items, so there must not be a BOM %v",
+				ymlFilePath, src)
+		}
+		b, err = rs.itemsBom(p)
 		if err != nil {
-			return sourceDir, fmt.Errorf("Error loading yml file: %v", err)
+			return sourceDir, fmt.Errorf("Error in %v: %v", ymlFilePath, err)
 		}
+		bomExists = true
+	}
 
-		rs := relScript{}
-		err = yaml.Unmarshal(ymlBytes, &rs)
+	if ymlExists && bomExists {
+		srcBom := b.copy()
+		b, err = rs.processBom(b, logErr)
 		if err != nil {
-			return sourceDir, fmt.Errorf("Error parsing yml: %v", err)
+			return sourceDir, fmt.Errorf("Error processing bom with yml file: %v", err)
 		}
+		plan(diffBoms("source", srcBom, relPn, b).text())
+	}
 
-		if bomExists {
-			b, err = rs.processBom(b)
+	// checkBom merges the partmaster into the BOM, finds the sub-assembly
+	// releases, and builds the -all and -tree BOMs. It runs before the
+	// release dir is made and the hooks run, so a strict release that fails
+	// leaves the release dir as it was. A BOM the hooks generate is only
+	// checked after they ran.
+	var problems []bomProblem
+	foundSub := false
+	pendingLinks := false
+	links := []string{}
+	linkDirs := map[string]string{}
+	var topBom bom
+	var tree bomTree
+	checkBom := func() error {
+		// always sort BOM for good measure
+		sort.Sort(b)
+
+		// merge in partmaster info into BOM
+		problems = b.mergePartmaster(p, logErr)
+		for i := range problems {
+			problems[i].BOM = relPn
+		}
+
+		// create combined BOM with all sub assemblies if we have any PCB or ASY line items
+		// process all special IPNS
+		// if BOM is found, then include in roll-up BOM
+		// find the release directories to soft link to
+		topBom = b.copy()
+		for _, l := range b {
+			// clear refs in purchase bom
+			l.Ref = ""
+			isOurs, _ := l.IPN.isOurIPN()
+			if isOurs {
+				if _, pending := dryRunPending.Load(l.IPN); dryRunRelease && pending {
+					logErr(fmt.Sprintf("Would link %v to the release of %v made before it, "+
+						"and add its parts to the -all and -tree BOMs\n",
+						path.Join(releaseDir, l.IPN.String()), l.IPN))
+					pendingLinks = true
+					continue
+				}
+				// look for release package
+				dir, err := findDir(l.IPN.String())
+				if err != nil {
+					return fmt.Errorf("Missing release package: %v", err)
+				}
+				dirRel, err := filepath.Rel(releaseDir, dir)
+				if err != nil {
+					return fmt.Errorf("Error creating rel path for %v: %v",
+						dir, err)
+				}
+				linkPath := path.Join(releaseDir, l.IPN.String())
+				if _, ok := linkDirs[linkPath]; !ok {
+					links = append(links, linkPath)
+				}
+				linkDirs[linkPath] = dirRel
+				hasBOM, _ := l.IPN.hasBOM()
+				if hasBOM {
+					foundSub = true
+					err = b.processOurIPN(l.IPN, l.Qty, []ipn{relIpn})
+					if err != nil {
+						return fmt.Errorf("Error proccessing sub %v: %v", l.IPN, err)
+					}
+				}
+			}
+		}
+
+		if foundSub {
+			// indented BOM that shows where each line comes from
+			var err error
+			tree, err = buildBomTree(relIpn, topBom)
 			if err != nil {
-				return sourceDir, fmt.Errorf("Error processing bom with yml file: %v", err)
+				return fmt.Errorf("Error building indented BOM: %v", err)
+			}
+
+			// sort first, so problems are reported by line of the written BOM
+			sort.Sort(b)
+			// merge in partmaster info into BOM
+			reported := map[ipn]bool{}
+			for _, pr := range problems {
+				reported[pr.IPN] = true
+			}
+			for _, pr := range b.mergePartmaster(p, logErr) {
+				// parts of sub-assemblies, the others are reported already
+				if !reported[pr.IPN] {
+					pr.BOM = relPn + "-all"
+					problems = append(problems, pr)
+				}
 			}
 		}
 
+		if len(problems) > 0 && (strictRelease || dryRunRelease) {
+			logErr("BOM problems:\n" + formatBomProblems(problems))
+		}
+		if strictRelease && len(problems) > 0 {
+			if !dryRunRelease {
+				return fmt.Errorf("%v BOM problems, release not allowed in strict mode", len(problems))
+			}
+			logErr(fmt.Sprintf("Would fail with %v BOM problems in strict mode\n", len(problems)))
+		}
+		return nil
+	}
+
+	if bomExists {
+		err = checkBom()
+		if err != nil {
+			return sourceDir, err
+		}
+	}
+
+	// Create output release dir
+	dirExists, err := exists(releaseDir)
+	if err != nil {
+		return sourceDir, err
+	}
+
+	switch {
+	case dirExists:
+	case dryRunRelease:
+		logErr(fmt.Sprintf("Would create %v\n", releaseDir))
+	default:
+		err = os.Mkdir(releaseDir, 0755)
+		if err != nil {
+			return sourceDir, err
+		}
+	}
+
+	if ymlExists {
+		// the same paths for the templates of every rule
+		tmplData, err := newRelTemplateData(relPn, sourceDir, releaseDir)
+		if err != nil {
+			return sourceDir, err
+		}
+
 		// run hooks
-		err = rs.hooks(relPn, sourceDir, releaseDir)
+		err = rs.hooks(tmplData, pmDir, logErr)
 		if err != nil {
 			return sourceDir, fmt.Errorf("Error running hooks specified in YML: %v", err)
 		}
 
 		// look if we generated a BOM
-		if !bomExists {
+		if !bomExists && dryRunRelease {
+			logErr(fmt.Sprintf("Would look for %v generated by the hooks\n", bomFileGenerated))
+		} else if !bomExists {
 			bomFilePath, err := findFile(bomFileGenerated)
 			if err == nil {
 				bomExists = true
@@ -160,40 +351,49 @@ func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string
 					return sourceDir, err
 				}
 
-				b, err = rs.processBom(b)
+				b, err = rs.processBom(b, logErr)
 				if err != nil {
 					return sourceDir, fmt.Errorf("Error processing bom with yml file: %v", err)
 				}
+
+				err = checkBom()
+				if err != nil {
+					return sourceDir, err
+				}
 			}
 		}
 
 		// copy stuff to release dir specified in YML file
-		err = rs.copy(sourceDir, releaseDir)
+		err = rs.copy(tmplData, logErr)
 		if err != nil {
 			return sourceDir, fmt.Errorf("Error copying files specified in YML: %v", err)
 		}
 
 		// check if required files are present in release
-		err = rs.required(releaseDir)
-		if err != nil {
-			return sourceDir, err
+		if dryRunRelease {
+			for _, r := range rs.Required {
+				logErr(fmt.Sprintf("Would check required %v\n", r))
+			}
+		} else {
+			err = rs.required(tmplData, sourceTime)
+			if err != nil {
+				return sourceDir, err
+			}
 		}
 	}
 
 	if !bomExists {
 		// nothing else to do
-		return sourceDir, nil
+		return sourceDir, finishRelease(relPn, releaseDir, commit, pmFiles, rs, changes, logErr)
 	}
 
-	// always sort BOM for good measure
-	sort.Sort(b)
-
-	// merge in partmaster info into BOM
-	b.mergePartmaster(p, logErr)
-
-	err = saveCSV(bomFileWritePath, b)
-	if err != nil {
-		return sourceDir, fmt.Errorf("Error writing BOM: %v", err)
+	if dryRunRelease {
+		logErr(fmt.Sprintf("Would write %v with %v lines\n", bomFileWritePath, len(topBom)))
+	} else {
+		err = saveBomCSV(bomFileWritePath, topBom)
+		if err != nil {
+			return sourceDir, fmt.Errorf("Error writing BOM: %v", err)
+		}
 	}
 
 	// copy MFG.md and CHANGELOG.md if they exist
@@ -204,7 +404,9 @@ func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string
 		if err != nil {
 			return sourceDir, err
 		}
-		if aPathExists {
+		if aPathExists && dryRunRelease {
+			logErr(fmt.Sprintf("Would copy %v to release dir\n", a))
+		} else if aPathExists {
 			aDest := path.Join(releaseDir, a)
 			data, err := os.ReadFile(aPath)
 			if err != nil {
@@ -218,57 +420,145 @@ func processRelease(relPn string, relLog *strings.Builder, pmDir string) (string
 		}
 	}
 
-	// create combined BOM with all sub assemblies if we have any PCB or ASY line items
-	// process all special IPNS
-	// if BOM is found, then include in roll-up BOM
-	// create soft link to release directory
-	foundSub := false
-	for _, l := range b {
-		// clear refs in purchase bom
-		l.Ref = ""
-		isOurs, _ := l.IPN.isOurIPN()
-		if isOurs {
-			// look for release package
-			dir, err := findDir(l.IPN.String())
-			if err != nil {
-				return sourceDir, fmt.Errorf("Missing release package: %v", err)
-			}
-			// soft link to that package
-			dirRel, err := filepath.Rel(releaseDir, dir)
-			if err != nil {
-				return sourceDir, fmt.Errorf("Error creating rel path for %v: %v",
-					dir, err)
-			}
-			linkPath := path.Join(releaseDir, l.IPN.String())
-			os.Remove(linkPath)
-			err = os.Symlink(dirRel, linkPath)
-			if err != nil {
-				return sourceDir, fmt.Errorf("Error creating symlink %v: %v",
-					dir, err)
-			}
-			hasBOM, _ := l.IPN.hasBOM()
-			if hasBOM {
-				foundSub = true
-				err = b.processOurIPN(l.IPN, l.Qty)
-				if err != nil {
-					return sourceDir, fmt.Errorf("Error proccessing sub %v: %v", l.IPN, err)
-				}
-			}
+	// soft link to the sub-assembly release packages
+	for _, linkPath := range links {
+		if dryRunRelease {
+			logErr(fmt.Sprintf("Would link %v to %v\n", linkPath, linkDirs[linkPath]))
+			continue
+		}
+		os.Remove(linkPath)
+		err = os.Symlink(linkDirs[linkPath], linkPath)
+		if err != nil {
+			return sourceDir, fmt.Errorf("Error creating symlink %v: %v",
+				linkPath, err)
 		}
 	}
 
 	if foundSub {
-		// merge in partmaster info into BOM
-		b.mergePartmaster(p, logErr)
+		// write out indented BOM that shows where each line comes from
+		if dryRunRelease {
+			logErr(fmt.Sprintf("Would write %v and %v with %v lines\n",
+				filepath.Join(releaseDir, relPn+"-tree.csv"),
+				filepath.Join(releaseDir, relPn+"-tree.md"), len(tree)))
+		} else {
+			err = tree.save(filepath.Join(releaseDir, relPn+"-tree"))
+			if err != nil {
+				return sourceDir, fmt.Errorf("Error writing indented BOM: %v", err)
+			}
+		}
+
 		// write out combined BOM
-		sort.Sort(b)
 		writePath := filepath.Join(releaseDir, relPn+"-all.csv")
 		// write out purchase bom
-		err := saveCSV(writePath, b)
+		if dryRunRelease {
+			logErr(fmt.Sprintf("Would write %v with %v lines\n", writePath, len(b)))
+		} else {
+			err = saveBomCSV(writePath, b)
+			if err != nil {
+				return sourceDir, fmt.Errorf("Error writing purchase bom %v", err)
+			}
+		}
+	}
+
+	if dryRunRelease {
+		if len(links) > 0 || pendingLinks {
+			logErr(fmt.Sprintf("Would write %v\n", filepath.Join(releaseDir, "CHANGELOG-all.md")))
+		}
+		return sourceDir, finishRelease(relPn, releaseDir, commit, pmFiles, rs, changes, logErr)
+	}
+
+	// collect the changes of all sub-assemblies into one changelog
+	_, err = writeChangelogAll(relPn, sourceDir, releaseDir, changes)
+	if err != nil {
+		return sourceDir, fmt.Errorf("Error writing combined changelog: %v", err)
+	}
+
+	return sourceDir, finishRelease(relPn, releaseDir, commit, pmFiles, rs, changes, logErr)
+}
+
+// finishRelease writes the release notes once the release has succeeded, the
+// archive of the release dir if the release has one, then the manifest, which
+// records the archive
+func finishRelease(relPn, releaseDir, commit string, pmFiles []string, rs relScript,
+	changes *changelogSection, logErr func(string)) error {
+	if dryRunRelease {
+		logErr(fmt.Sprintf("Would write %v\n", filepath.Join(releaseDir, "RELEASE_NOTES.md")))
+		if a := rs.archive(); a.Format != "" {
+			logErr(fmt.Sprintf("Would write archive %v\n",
+				filepath.Join(filepath.Dir(releaseDir), relPn+"."+a.Format)))
+		}
+		logErr(fmt.Sprintf("Would write %v\n", filepath.Join(releaseDir, manifestFile)))
+		return nil
+	}
+
+	err := writeReleaseNotes(releaseDir, changes)
+	if err != nil {
+		return fmt.Errorf("Error writing release notes: %v", err)
+	}
+
+	archivePath := ""
+	if a := rs.archive(); a.Format != "" {
+		archivePath, err = writeArchive(relPn, releaseDir, a)
+		if err != nil {
+			return fmt.Errorf("Error writing archive: %v", err)
+		}
+		sum, _, err := hashFile(archivePath)
 		if err != nil {
-			return sourceDir, fmt.Errorf("Error writing purchase bom %v", err)
+			return err
+		}
+		logErr(fmt.Sprintf("Archive: %v sha256 %v\n", archivePath, sum))
+	}
+
+	err = writeManifest(relPn, releaseDir, commit, pmFiles, archivePath)
+	if err != nil {
+		return fmt.Errorf("Error writing manifest: %v", err)
+	}
+	return nil
+}
+
+// findReleaseSource finds the source BOM (CCC-NNN.csv or CCC-NNN-VV.csv) and
+// release script (CCC-NNN.yml or CCC-NNN-VV.yml) of a release. Either path is
+// "" if the file does not exist, but not both.
+func findReleaseSource(relPn string) (string, string, error) {
+	relIpn := ipn(relPn)
+	_, _, v, err := relIpn.parse()
+	if err != nil {
+		return "", "", fmt.Errorf("error parsing bom %v IPN : %v", relPn, err)
+	}
+
+	relPnBase := relIpn.base()
+	names := []string{relPnBase}
+	if variationPrefixLen > 0 {
+		names = append(names, relPnBase+"-"+variationPrefix(v))
+	}
+
+	find := func(ext string) string {
+		// first try CCC-NNN, then CCC-NNN-VV
+		for _, name := range names {
+			name += ext
+			p, err := findFile(name)
+			if err == nil {
+				return p
+			}
+		}
+		return ""
+	}
+
+	bomFilePath := find(".csv")
+	ymlFilePath := find(".yml")
+
+	if bomFilePath == "" && ymlFilePath == "" {
+		return "", "", errors.New("Could not find BOM or YML file for release IPN")
+	}
+
+	if bomFilePath != "" && ymlFilePath != "" {
+		bomDir := filepath.Dir(bomFilePath)
+		ymlDir := filepath.Dir(ymlFilePath)
+
+		if bomDir != ymlDir {
+			return "", "", fmt.Errorf("BOM and YML files should be in the same directory: %v %v", bomFilePath, ymlFilePath)
 		}
 	}
 
-	return sourceDir, nil
+	return bomFilePath, ymlFilePath, nil
 }
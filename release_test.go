@@ -0,0 +1,439 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// releaseTree is a small product: ASY-001 contains two PCA-019 boards and a
+// screw, and PCA-019 is a bare board with two resistors on it.
+var releaseTree = map[string]string{
+	"pm/pca.csv": `IPN,Description,Manufacturer,MPN,Checked
+PCA-019-0000,PCB assembly,mycompany,,Y
+`,
+	"pm/pcb.csv": `IPN,Description,Manufacturer,MPN,Checked
+PCB-019-0001,bare board,BoardHouse,PCB-019-0001,Y
+`,
+	"pm/asy.csv": `IPN,Description,Manufacturer,MPN,Checked
+ASY-001-0000,product,mycompany,,Y
+`,
+	"pm/res.csv": `IPN,Description,Manufacturer,MPN,Checked
+RES-001-1002,10k 0603,Yageo,RC0603FR-0710KL,Y
+`,
+	"pm/scr.csv": `IPN,Description,Manufacturer,MPN,Checked
+SCR-002-0002,screw #4,Screws Inc,S4,Y
+`,
+	"src/elec/PCA-019.csv": `Ref,Qty,Value,Cmp name,Footprint,Description,Vendor,IPN,Datasheet
+R1 R2,2,10k,R,,,,RES-001-1002,
+PCB1,1,,PCB,,,,PCB-019-0001,
+`,
+	"src/elec/PCB-019.yml": `description: bare board
+`,
+	"src/elec/CHANGELOG.md": `# Changelog
+
+## [PCA-019-0000] - 2026-01-02
+
+- first assembly
+
+## [PCB-019-0001] - 2026-01-01
+
+- first board
+`,
+	"src/CHANGELOG.md": `# Changelog
+
+## [ASY-001-0000] - 2026-01-03
+
+- first product
+`,
+	"src/ASY-001.csv": `IPN,Qty
+PCA-019-0000,2
+SCR-002-0002,4
+`,
+}
+
+// writeTree writes files below dir, creating directories as needed
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// chdir changes into dir for the rest of the test, as release processing
+// searches the tree below the working directory.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+}
+
+// setupReleaseTree writes releaseTree to a temporary directory, changes into
+// its src dir, and returns the partmaster dir.
+func setupReleaseTree(t *testing.T) string {
+	t.Helper()
+	initCSV()
+	dir := t.TempDir()
+	writeTree(t, dir, releaseTree)
+	chdir(t, filepath.Join(dir, "src"))
+	return filepath.Join(dir, "pm")
+}
+
+func release(t *testing.T, pn, pmDir string) string {
+	t.Helper()
+	var relLog strings.Builder
+	srcDir, err := processRelease(pn, &relLog, pmDir)
+	if err != nil {
+		t.Fatalf("release %v: %v\n%v", pn, err, relLog.String())
+	}
+	return filepath.Join(srcDir, pn)
+}
+
+func TestReleaseBomTree(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+
+	release(t, "PCB-019-0001", pmDir)
+	release(t, "PCA-019-0000", pmDir)
+	relDir := release(t, "ASY-001-0000", pmDir)
+
+	tree := bomTree{}
+	err := loadCSV(filepath.Join(relDir, "ASY-001-0000-tree.csv"), &tree)
+	if err != nil {
+		t.Fatalf("error loading tree: %v", err)
+	}
+
+	exp := []bomTreeLine{
+		{Level: 0, IPN: "ASY-001-0000", Qty: 1, ExtQty: 1},
+		{Level: 1, IPN: "PCA-019-0000", Parent: "ASY-001-0000", Qty: 2, ExtQty: 2},
+		{Level: 2, IPN: "PCB-019-0001", Parent: "PCA-019-0000", Qty: 1, ExtQty: 2},
+		{Level: 2, IPN: "RES-001-1002", Parent: "PCA-019-0000", Qty: 2, ExtQty: 4},
+		{Level: 1, IPN: "SCR-002-0002", Parent: "ASY-001-0000", Qty: 4, ExtQty: 4},
+	}
+
+	if len(tree) != len(exp) {
+		t.Fatalf("expected %v tree lines, got %v", len(exp), len(tree))
+	}
+
+	for i, e := range exp {
+		l := tree[i]
+		if l.Level != e.Level || l.IPN != e.IPN || l.Parent != e.Parent ||
+			l.Qty != e.Qty || l.ExtQty != e.ExtQty {
+			t.Errorf("tree line %v: got %+v, want %+v", i, *l, e)
+		}
+	}
+
+	if tree[3].Ref != "R1 R2" || tree[3].MPN != "RC0603FR-0710KL" {
+		t.Errorf("tree line 3 is missing refs or MPN: %+v", *tree[3])
+	}
+
+	md, err := os.ReadFile(filepath.Join(relDir, "ASY-001-0000-tree.md"))
+	if err != nil {
+		t.Fatalf("error reading tree markdown: %v", err)
+	}
+	if !strings.Contains(string(md), "| ..2 | RES-001-1002 | PCA-019-0000 | 2 | 4 |") {
+		t.Errorf("tree markdown is missing RES line:\n%s", md)
+	}
+}
+
+func TestStrictRelease(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+
+	// the resistors are missing, and the screw has no MPN and is not checked
+	writeTree(t, pmDir, map[string]string{
+		"res.csv": "IPN,Description,Manufacturer,MPN,Checked\n",
+		"scr.csv": "IPN,Description,Manufacturer,MPN,Checked\nSCR-002-0002,screw #4,Screws Inc,,\n",
+	})
+
+	release(t, "PCB-019-0001", pmDir)
+	release(t, "PCA-019-0000", pmDir)
+
+	writeTree(t, ".", map[string]string{
+		"ASY-001.yml": `hooks:
+  - name: touch
+    run: touch hooked
+copy:
+  - MFG.md
+`,
+		"MFG.md": "build it\n",
+	})
+
+	strictRelease = true
+	t.Cleanup(func() { strictRelease = false })
+
+	var relLog strings.Builder
+	_, err := processRelease("ASY-001-0000", &relLog, pmDir)
+	if err == nil || err.Error() != "3 BOM problems, release not allowed in strict mode" {
+		t.Fatalf("strict release error: %v", err)
+	}
+
+	exp := `BOM problems:
+BOM               Line  IPN           Problem
+ASY-001-0000      3     SCR-002-0002  no MPN
+ASY-001-0000      3     SCR-002-0002  not checked
+ASY-001-0000-all  4     RES-001-1002  not in partmaster
+`
+	if !strings.Contains(relLog.String(), exp) {
+		t.Errorf("release log does not list the problems:\n%v", relLog.String())
+	}
+
+	// the check runs before the release dir is made and the hooks run
+	if e, _ := exists("ASY-001-0000"); e {
+		t.Errorf("release dir made for a failed release")
+	}
+	if e, _ := exists("hooked"); e {
+		t.Errorf("hooks ran for a failed release")
+	}
+}
+
+func TestReleaseItems(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+
+	release(t, "PCB-019-0001", pmDir)
+	release(t, "PCA-019-0000", pmDir)
+
+	writeTree(t, ".", map[string]string{
+		"mech/ASY-002.yml": `description: bracket
+items:
+  - ipn: PCA-019-0000
+  - ipn: SCR-002-0002
+    qty: 2
+    notes: thread locker
+`,
+		"mech/CHANGELOG.md": "# Changelog\n\n## [ASY-002-0000]\n\n- first bracket\n",
+	})
+
+	relDir := release(t, "ASY-002-0000", pmDir)
+
+	b := bom{}
+	err := loadCSV(filepath.Join(relDir, "ASY-002-0000.csv"), &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 2 || b[1].IPN != "SCR-002-0002" || b[1].MPN != "S4" || b[1].Notes != "thread locker" {
+		t.Errorf("items BOM: %v", b)
+	}
+
+	if e, _ := exists(filepath.Join(relDir, "ASY-002-0000-all.csv")); !e {
+		t.Error("no roll-up BOM for the board in the items")
+	}
+
+	// only BOMs with notes have a Notes column
+	for _, test := range []struct {
+		path  string
+		notes bool
+	}{
+		{filepath.Join(relDir, "ASY-002-0000.csv"), true},
+		{filepath.Join("elec", "PCA-019-0000", "PCA-019-0000.csv"), false},
+	} {
+		data, err := os.ReadFile(test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, _, _ := strings.Cut(string(data), "\n")
+		if strings.HasSuffix(header, ",Notes") != test.notes {
+			t.Errorf("%v header: %v", test.path, header)
+		}
+	}
+
+	// a CSV BOM and items are two BOMs for one assembly
+	writeTree(t, ".", map[string]string{"mech/ASY-002.csv": "IPN,Qty\nSCR-002-0002,1\n"})
+	var relLog strings.Builder
+	_, err = processRelease("ASY-002-0000", &relLog, pmDir)
+	if err == nil || !strings.Contains(err.Error(), "has items, so there must not be a BOM") {
+		t.Errorf("release with items and a CSV BOM: %v", err)
+	}
+}
+
+func TestReleaseVariants(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+
+	// the 0001 build option does not stuff R2
+	writeTree(t, ".", map[string]string{
+		"elec/PCA-019.yml": `variants:
+  "0001":
+    remove:
+      - ref: R2
+`,
+		"elec/CHANGELOG.md": `# Changelog
+
+## [PCA-019-0001] - 2026-01-03
+
+- R2 not stuffed
+
+## [PCA-019-0000] - 2026-01-02
+
+- first assembly
+
+## [PCB-019-0001] - 2026-01-01
+
+- first board
+`,
+	})
+
+	release(t, "PCB-019-0001", pmDir)
+
+	for _, test := range []struct {
+		pn  string
+		qty float64
+		ref string
+	}{
+		{"PCA-019-0000", 2, "R1 R2"},
+		{"PCA-019-0001", 1, "R1"},
+	} {
+		relDir := release(t, test.pn, pmDir)
+		b := bom{}
+		err := loadCSV(filepath.Join(relDir, test.pn+".csv"), &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range b {
+			if l.IPN == "RES-001-1002" && (l.Qty != test.qty || l.Ref != test.ref) {
+				t.Errorf("%v: resistor line %v", test.pn, l)
+			}
+		}
+	}
+}
+
+func TestReleaseDryRun(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+
+	release(t, "PCB-019-0001", pmDir)
+	release(t, "PCA-019-0000", pmDir)
+
+	writeTree(t, ".", map[string]string{
+		"ASY-001.yml": `remove:
+  - ipn: SCR-002-0002
+hooks:
+  - name: touch
+    run: touch hooked
+copy:
+  - MFG.md
+required:
+  - ASY-001-0000.html
+archive: zip
+`,
+		"MFG.md": "build it\n",
+	})
+
+	before := map[string]time.Time{}
+	snapshot := func(m map[string]time.Time) {
+		err := filepath.WalkDir("..", func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			m[p] = info.ModTime()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshot(before)
+
+	dryRunRelease = true
+	t.Cleanup(func() { dryRunRelease = false })
+
+	var relLog strings.Builder
+	_, err := processRelease("ASY-001-0000", &relLog, pmDir)
+	if err != nil {
+		t.Fatalf("dry run: %v\n%v", err, relLog.String())
+	}
+
+	after := map[string]time.Time{}
+	snapshot(after)
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("dry run changed the tree")
+	}
+
+	for _, exp := range []string{
+		"Source dir: .\n",
+		"BOM: ASY-001.csv\n",
+		"Release script: ASY-001.yml\n",
+		"Would create ASY-001-0000\n",
+		"remove ipn: SCR-002-0002: SCR-002-0002\n",
+		"Removed:\n  SCR-002-0002: qty 4\n",
+		"touch: would run:\ntouch hooked\n",
+		"Would copy MFG.md to release dir\n",
+		"Would check required ASY-001-0000.html\n",
+		"Would write ASY-001-0000/ASY-001-0000.csv with 1 lines\n",
+		"Would link ASY-001-0000/PCA-019-0000 to ../elec/PCA-019-0000\n",
+		"Would write ASY-001-0000/ASY-001-0000-tree.csv and ASY-001-0000/ASY-001-0000-tree.md with 4 lines\n",
+		"Would write ASY-001-0000/ASY-001-0000-all.csv with 3 lines\n",
+		"Would write archive ASY-001-0000.zip\n",
+		"Would write ASY-001-0000/manifest.yml\n",
+	} {
+		if !strings.Contains(relLog.String(), exp) {
+			t.Errorf("dry run log has no %q:\n%v", exp, relLog.String())
+		}
+	}
+
+	// in a recursive dry run, the sub-assembly is released first
+	err = os.RemoveAll(filepath.Join("elec", "PCA-019-0000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dryRunPending.Store(ipn("PCA-019-0000"), true)
+	t.Cleanup(func() { dryRunPending.Delete(ipn("PCA-019-0000")) })
+	relLog.Reset()
+	_, err = processRelease("ASY-001-0000", &relLog, pmDir)
+	if err != nil {
+		t.Fatalf("dry run with a pending sub-assembly: %v\n%v", err, relLog.String())
+	}
+	exp := "Would link ASY-001-0000/PCA-019-0000 to the release of PCA-019-0000 made before it"
+	if !strings.Contains(relLog.String(), exp) {
+		t.Errorf("dry run log has no %q:\n%v", exp, relLog.String())
+	}
+}
+
+func TestReleaseKicad(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+	writeTree(t, ".", map[string]string{
+		"elec/PCA-019.yml": "kicad: pcb.xml\n",
+		"elec/pcb.xml":     netlistXML,
+	})
+
+	release(t, "PCB-019-0001", pmDir)
+	relDir := release(t, "PCA-019-0000", pmDir)
+
+	b := bom{}
+	err := loadCSV(filepath.Join(relDir, "PCA-019-0000.csv"), &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := []string{}
+	for _, l := range b {
+		refs = append(refs, l.Ref)
+	}
+	if !reflect.DeepEqual(refs, []string{"C1", "R2 R10"}) {
+		t.Errorf("released BOM refs = %v, want those of the netlist", refs)
+	}
+
+	// the source CSV is not regenerated
+	data, err := os.ReadFile(filepath.Join("elec", "PCA-019.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != releaseTree["src/elec/PCA-019.csv"] {
+		t.Errorf("source BOM changed by the release:\n%s", data)
+	}
+}
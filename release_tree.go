@@ -0,0 +1,403 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v2"
+)
+
+// releaseChildren returns the parts we make that the source BOM of pn uses,
+// whose releases a release of pn links to. A BOM that hooks generate at
+// release time cannot be read, so such a part has no children.
+func releaseChildren(pn ipn) ([]ipn, error) {
+	bomPath, ymlPath, err := findReleaseSource(pn.String())
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, v, _ := pn.parse()
+	b, err := loadSourceBom(bomPath, ymlPath, v)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading BOM of %v: %v", pn, err)
+	}
+
+	ret := []ipn{}
+	for _, l := range b {
+		isOurs, _ := l.IPN.isOurIPN()
+		if isOurs && !lo.Contains(ret, l.IPN) {
+			ret = append(ret, l.IPN)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+
+	return ret, nil
+}
+
+// releasePlan is the tree of releases below some top level parts
+type releasePlan struct {
+	// order lists the parts so each comes after the parts it uses
+	order []ipn
+	// children are the parts each part uses that have a source to release
+	// from. A part with a release dir but no source is released elsewhere,
+	// and is not in the plan.
+	children map[ipn][]ipn
+	// requested are the top level parts, which are always released
+	requested map[ipn]bool
+}
+
+// planReleases finds the parts that roots use, directly or through their
+// sub-assemblies, and orders them bottom-up. It fails on a BOM cycle, or on a
+// part with neither a source nor a release.
+func planReleases(roots []ipn) (*releasePlan, error) {
+	p := &releasePlan{
+		children:  map[ipn][]ipn{},
+		requested: map[ipn]bool{},
+	}
+	done := map[ipn]bool{}
+
+	var visit func(path []ipn, pn ipn) error
+	visit = func(path []ipn, pn ipn) error {
+		path, err := expandPath(path, pn)
+		if err != nil {
+			return err
+		}
+		if done[pn] {
+			return nil
+		}
+
+		children, err := releaseChildren(pn)
+		if err != nil {
+			return fmt.Errorf("%v: %v", pn, err)
+		}
+
+		for _, c := range children {
+			if _, _, err := findReleaseSource(c.String()); err != nil {
+				if _, err := findDir(c.String()); err != nil {
+					return fmt.Errorf("%v uses %v, which has no source and no release",
+						pn, c)
+				}
+				continue
+			}
+			p.children[pn] = append(p.children[pn], c)
+			err := visit(path, c)
+			if err != nil {
+				return err
+			}
+		}
+
+		done[pn] = true
+		p.order = append(p.order, pn)
+		return nil
+	}
+
+	for _, pn := range roots {
+		p.requested[pn] = true
+		err := visit(nil, pn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// releaseSources returns the files a release of pn is made from: its source
+// BOM, its release script, and the KiCad design the script names, with the
+// other sheets next to a schematic.
+func releaseSources(pn ipn) ([]string, error) {
+	bomPath, ymlPath, err := findReleaseSource(pn.String())
+	if err != nil {
+		return nil, err
+	}
+	ret := lo.Compact([]string{bomPath, ymlPath})
+	if ymlPath == "" {
+		return ret, nil
+	}
+
+	ymlBytes, err := os.ReadFile(ymlPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading yml file: %v", err)
+	}
+	rs := relScript{}
+	err = yaml.Unmarshal(ymlBytes, &rs)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing yml: %v", err)
+	}
+	if rs.Kicad == "" {
+		return ret, nil
+	}
+
+	kicadPath := filepath.Join(filepath.Dir(ymlPath), rs.Kicad)
+	ret = append(ret, kicadPath)
+	if filepath.Ext(kicadPath) == ".kicad_sch" {
+		sheets, err := filepath.Glob(filepath.Join(filepath.Dir(kicadPath), "*.kicad_sch"))
+		if err != nil {
+			return nil, err
+		}
+		ret = lo.Uniq(append(ret, sheets...))
+	}
+	return ret, nil
+}
+
+// releaseOutOfDate returns why pn needs to be released, or "" if its release
+// is up to date. A release is out of date if the partmaster files it records
+// changed, or if its sources changed since: in git since the commit the
+// manifest records, or otherwise after the manifest was written. A release
+// without a manifest cannot be compared, so it is made again.
+func releaseOutOfDate(pn ipn) (string, error) {
+	relDir, err := findDir(pn.String())
+	if err != nil {
+		return "no release dir", nil
+	}
+
+	m, err := loadManifest(relDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "no manifest", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for _, f := range m.Partmaster {
+		sum, _, err := hashFile(filepath.FromSlash(f.Path))
+		if err != nil || sum != f.SHA256 {
+			return "partmaster changed since release", nil
+		}
+	}
+
+	sources, err := releaseSources(pn)
+	if err != nil {
+		return "", err
+	}
+
+	// a release from a working tree with changes does not match its commit
+	if m.SourceCommit != "" && !strings.HasSuffix(m.SourceCommit, "-dirty") {
+		changed, err := git(".", append([]string{"diff", "--name-only", m.SourceCommit, "--"},
+			sources...)...)
+		if err == nil {
+			if changed != "" {
+				return "source changed since release", nil
+			}
+			return "", nil
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(relDir, manifestFile))
+	if err != nil {
+		return "", err
+	}
+	for _, s := range sources {
+		sInfo, err := os.Stat(s)
+		if err != nil {
+			return "", err
+		}
+		if sInfo.ModTime().After(info.ModTime()) {
+			return "source changed since release", nil
+		}
+	}
+
+	return "", nil
+}
+
+// releaseResult is the outcome of one release of a plan
+type releaseResult struct {
+	pn ipn
+	// reason is why the part was released, "" if it was up to date
+	reason string
+	srcDir string
+	log    string
+	err    error
+}
+
+// runReleases releases the parts of a plan that are requested, out of date,
+// or use a part that is released, each after the parts it uses. Independent
+// parts are released concurrently, up to jobs at a time. release is called for
+// each part to release, and done after each part, in the order they finish.
+// The results are returned in the order of the plan.
+func runReleases(p *releasePlan, jobs int,
+	release func(pn ipn, relLog *strings.Builder) (string, error),
+	done func(r releaseResult)) []releaseResult {
+	jobs = max(jobs, 1)
+	sem := make(chan struct{}, jobs)
+
+	var mu sync.Mutex
+	results := map[ipn]*releaseResult{}
+	finished := map[ipn]chan struct{}{}
+	for _, pn := range p.order {
+		finished[pn] = make(chan struct{})
+		results[pn] = &releaseResult{pn: pn}
+	}
+
+	var wg sync.WaitGroup
+	for _, pn := range p.order {
+		wg.Add(1)
+		go func(pn ipn) {
+			defer wg.Done()
+			r := results[pn]
+			defer func() {
+				mu.Lock()
+				done(*r)
+				mu.Unlock()
+				close(finished[pn])
+			}()
+
+			for _, c := range p.children[pn] {
+				<-finished[c]
+			}
+
+			mu.Lock()
+			for _, c := range p.children[pn] {
+				cr := results[c]
+				switch {
+				case cr.err != nil && r.err == nil:
+					r.err = fmt.Errorf("not released, as %v failed", c)
+				case cr.reason != "" && r.reason == "":
+					r.reason = fmt.Sprintf("%v released", c)
+				}
+			}
+			mu.Unlock()
+			if r.err != nil {
+				return
+			}
+
+			if p.requested[pn] {
+				r.reason = "requested"
+			}
+			if r.reason == "" {
+				r.reason, r.err = releaseOutOfDate(pn)
+				if r.err != nil || r.reason == "" {
+					return
+				}
+			}
+
+			sem <- struct{}{}
+			var relLog strings.Builder
+			r.srcDir, r.err = release(pn, &relLog)
+			<-sem
+			r.log = relLog.String()
+		}(pn)
+	}
+	wg.Wait()
+
+	ret := []releaseResult{}
+	for _, pn := range p.order {
+		ret = append(ret, *results[pn])
+	}
+	return ret
+}
+
+// findReleaseRoots returns the latest variation of each part we make that has
+// a source in the tree below the working directory, for releasing them all.
+// The variations of a part are those in the partmaster, released, or with a
+// CHANGELOG.md entry of their IPN, and the latest is the highest of those with
+// a CHANGELOG.md entry. Parts without one are returned in skipped.
+func findReleaseRoots(pmDir string) ([]ipn, []string, error) {
+	known := []ipn{}
+	if pmDir != "" {
+		c, err := loadAllCSVFiles(pmDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error loading partmaster: %v", err)
+		}
+		for _, f := range c.Files {
+			idx := findHeaderIndex(f.Headers, "IPN")
+			for _, row := range f.Rows {
+				if idx >= 0 && idx < len(row) {
+					known = append(known, ipn(strings.TrimSpace(row[idx])))
+				}
+			}
+		}
+	} else if pmPath, err := findFile("partmaster.csv"); err == nil {
+		p := partmaster{}
+		err := loadCSV(pmPath, &p)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, l := range p {
+			known = append(known, l.IPN)
+		}
+	}
+
+	// the source files of our parts, by base, outside release dirs
+	sources := map[string]string{}
+	err := fs.WalkDir(os.DirFS("./"), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != "." && (strings.HasPrefix(d.Name(), ".") || reIpn.MatchString(d.Name())) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		ext := filepath.Ext(d.Name())
+		if ext != ".csv" && ext != ".yml" {
+			return nil
+		}
+		groups := reSourceBase.FindStringSubmatch(strings.TrimSuffix(d.Name(), ext))
+		if len(groups) < 3 || !lo.Contains(ourIPNs, groups[1]) {
+			return nil
+		}
+		sources[groups[1]+"-"+groups[2]] = filepath.Dir(path)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bases := lo.Keys(sources)
+	sort.Strings(bases)
+
+	roots := []ipn{}
+	skipped := []string{}
+	for _, base := range bases {
+		srcDir := sources[base]
+		candidates := []ipn{}
+		for _, pn := range known {
+			if pn.base() == base {
+				candidates = append(candidates, pn)
+			}
+		}
+		released, err := findReleaseDirs(base)
+		if err != nil {
+			return nil, nil, err
+		}
+		candidates = append(candidates, lo.Keys(released)...)
+		if data, err := os.ReadFile(filepath.Join(srcDir, "CHANGELOG.md")); err == nil {
+			for _, s := range parseChangelog(string(data)) {
+				if pn, err := newIpn(s.Version); err == nil && pn.base() == base {
+					candidates = append(candidates, pn)
+				}
+			}
+		}
+
+		latest := ipn("")
+		for _, pn := range lo.Uniq(candidates) {
+			if pn <= latest {
+				continue
+			}
+			changes, err := releaseChangelogSection(srcDir, pn.String())
+			if err != nil {
+				return nil, nil, err
+			}
+			if changes != nil {
+				latest = pn
+			}
+		}
+
+		if latest == "" {
+			skipped = append(skipped, fmt.Sprintf("%v: no CHANGELOG.md entry in %v", base, srcDir))
+			continue
+		}
+		roots = append(roots, latest)
+	}
+
+	return roots, skipped, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPlanReleases(t *testing.T) {
+	setupReleaseTree(t)
+
+	p, err := planReleases([]ipn{"ASY-001-0000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expOrder := []ipn{"PCB-019-0001", "PCA-019-0000", "ASY-001-0000"}
+	if !reflect.DeepEqual(p.order, expOrder) {
+		t.Errorf("order = %v, want %v", p.order, expOrder)
+	}
+	expChildren := map[ipn][]ipn{
+		"ASY-001-0000": {"PCA-019-0000"},
+		"PCA-019-0000": {"PCB-019-0001"},
+	}
+	if !reflect.DeepEqual(p.children, expChildren) {
+		t.Errorf("children = %v, want %v", p.children, expChildren)
+	}
+
+	// the board uses the product it is in
+	writeTree(t, ".", map[string]string{
+		"elec/PCB-019.yml": `items:
+  - ipn: ASY-001-0000
+`,
+	})
+	_, err = planReleases([]ipn{"ASY-001-0000"})
+	exp := "BOM cycle: ASY-001-0000 -> PCA-019-0000 -> PCB-019-0001 -> ASY-001-0000"
+	if err == nil || err.Error() != exp {
+		t.Errorf("planReleases() error = %v, want %v", err, exp)
+	}
+}
+
+func TestRunReleases(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+
+	run := func(jobs int) map[ipn]string {
+		t.Helper()
+		p, err := planReleases([]ipn{"ASY-001-0000"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		finished := []ipn{}
+		results := runReleases(p, jobs, func(pn ipn, relLog *strings.Builder) (string, error) {
+			return processRelease(pn.String(), relLog, pmDir)
+		}, func(r releaseResult) { finished = append(finished, r.pn) })
+
+		if len(finished) != len(results) {
+			t.Errorf("done called for %v, want each of %v", finished, p.order)
+		}
+		ret := map[ipn]string{}
+		for _, r := range results {
+			ret[r.pn] = r.reason
+			if r.err != nil {
+				ret[r.pn] = "error: " + r.err.Error()
+			}
+		}
+		return ret
+	}
+
+	got := run(4)
+	exp := map[ipn]string{
+		"PCB-019-0001": "no release dir",
+		"PCA-019-0000": "PCB-019-0001 released",
+		"ASY-001-0000": "requested",
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("first run: %v, want %v", got, exp)
+	}
+
+	got = run(4)
+	exp = map[ipn]string{
+		"PCB-019-0001": "",
+		"PCA-019-0000": "",
+		"ASY-001-0000": "requested",
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("run when up to date: %v, want %v", got, exp)
+	}
+
+	// the board changed since it was released
+	later := time.Now().Add(time.Hour)
+	err := os.Chtimes(filepath.Join("elec", "PCB-019.yml"), later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = run(1)
+	exp = map[ipn]string{
+		"PCB-019-0001": "source changed since release",
+		"PCA-019-0000": "PCB-019-0001 released",
+		"ASY-001-0000": "requested",
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("run after a change: %v, want %v", got, exp)
+	}
+
+	// a failed release stops the releases that use it
+	writeTree(t, ".", map[string]string{
+		"elec/PCB-019.yml": `required:
+  - gerbers.zip
+`,
+	})
+	got = run(4)
+	if !strings.HasPrefix(got["PCB-019-0001"], "error: required files:") ||
+		got["PCA-019-0000"] != "error: not released, as PCB-019-0001 failed" ||
+		got["ASY-001-0000"] != "error: not released, as PCA-019-0000 failed" {
+		t.Errorf("run with a failure: %v", got)
+	}
+}
+
+func TestFindReleaseRoots(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+	writeTree(t, ".", map[string]string{
+		"mech/ASY-002.yml": "description: bracket\n",
+	})
+
+	roots, skipped, err := findReleaseRoots(pmDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expRoots := []ipn{"ASY-001-0000", "PCA-019-0000", "PCB-019-0001"}
+	if !reflect.DeepEqual(roots, expRoots) {
+		t.Errorf("roots = %v, want %v", roots, expRoots)
+	}
+	expSkipped := []string{"ASY-002: no CHANGELOG.md entry in mech"}
+	if !reflect.DeepEqual(skipped, expSkipped) {
+		t.Errorf("skipped = %v, want %v", skipped, expSkipped)
+	}
+}
+
+func TestRunReleasesConcurrent(t *testing.T) {
+	// two boards, then the assembly that uses both
+	p := &releasePlan{
+		order:     []ipn{"PCA-001-0001", "PCA-002-0001", "ASY-001-0001"},
+		children:  map[ipn][]ipn{"ASY-001-0001": {"PCA-001-0001", "PCA-002-0001"}},
+		requested: map[ipn]bool{"PCA-001-0001": true, "PCA-002-0001": true, "ASY-001-0001": true},
+	}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	finished := []ipn{}
+	runReleases(p, 2, func(pn ipn, relLog *strings.Builder) (string, error) {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return "", nil
+	}, func(r releaseResult) { finished = append(finished, r.pn) })
+
+	if maxRunning != 2 {
+		t.Errorf("%v releases ran at once, want 2", maxRunning)
+	}
+	if len(finished) != 3 || finished[2] != "ASY-001-0001" {
+		t.Errorf("releases finished in order %v, want the assembly last", finished)
+	}
+}
+
+func TestReleaseOutOfDate(t *testing.T) {
+	pmDir := setupGitReleaseTree(t)
+	root := filepath.Dir(pmDir)
+	writeTree(t, ".", map[string]string{
+		"elec/PCA-019.yml": "kicad: pcb.xml\nadd:\n  - ipn: PCB-019-0001\n",
+		"elec/pcb.xml":     netlistXML,
+	})
+	gitT(t, root, "add", "-A")
+	gitT(t, root, "commit", "-q", "-m", "read the board BOM from KiCad")
+
+	run := func() map[ipn]string {
+		t.Helper()
+		p, err := planReleases([]ipn{"PCA-019-0000"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.requested = map[ipn]bool{}
+		ret := map[ipn]string{}
+		for _, r := range runReleases(p, 2, func(pn ipn, relLog *strings.Builder) (string, error) {
+			return processRelease(pn.String(), relLog, pmDir)
+		}, func(releaseResult) {}) {
+			ret[r.pn] = r.reason
+			if r.err != nil {
+				t.Fatalf("release %v: %v\n%v", r.pn, r.err, r.log)
+			}
+		}
+		return ret
+	}
+
+	got := run()
+	exp := map[ipn]string{"PCB-019-0001": "no release dir", "PCA-019-0000": "PCB-019-0001 released"}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("first run: %v, want %v", got, exp)
+	}
+
+	// the releases of the first run are not committed, and do not make the
+	// parent's source commit dirty
+	got = run()
+	exp = map[ipn]string{"PCB-019-0001": "", "PCA-019-0000": ""}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("second run: %v, want %v", got, exp)
+	}
+
+	check := func(what, want string) {
+		t.Helper()
+		reason, err := releaseOutOfDate("PCA-019-0000")
+		if err != nil || reason != want {
+			t.Errorf("%v: releaseOutOfDate() = %q, %v, want %q", what, reason, err, want)
+		}
+	}
+
+	writeTree(t, ".", map[string]string{
+		"elec/pcb.xml": strings.Replace(netlistXML, "R10", "R11", 1),
+	})
+	check("KiCad design changed", "source changed since release")
+	gitT(t, root, "checkout", "--", ".")
+
+	writeTree(t, pmDir, map[string]string{
+		"res.csv": "IPN,Description,Manufacturer,MPN,Checked\nRES-001-1002,10k 0603,Yageo,RC0603FR-0710KX,Y\n",
+	})
+	check("partmaster changed", "partmaster changed since release")
+	gitT(t, root, "checkout", "--", ".")
+	check("partmaster restored", "")
+
+	// releases made before manifests cannot be compared
+	err := os.Remove(filepath.Join("elec", "PCA-019-0000", manifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("no manifest", "no manifest")
+}
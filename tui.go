@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"io"
 
@@ -29,6 +31,7 @@ const (
 	modeParametricSearch
 	modeDetail
 	modeRelease
+	modeWhereUsed
 )
 
 const allFilesOption = "All Parts (Combined)"
@@ -158,6 +161,12 @@ type modelNew struct {
 	releaseLog    string
 	releaseScroll int
 	releaseError  bool
+	releaseIPN    string
+	releaseSrcDir string
+
+	// Where-used overlay
+	whereUsedLog    string
+	whereUsedScroll int
 }
 
 func initialModelNew(needsPMDir bool, pmDir string, updateMsg string) modelNew {
@@ -499,6 +508,103 @@ func (m *modelNew) applySearchFilter(query string) {
 	}
 }
 
+// selectedIPN returns the IPN of the part under the table cursor, or "" if
+// there is none.
+func (m *modelNew) selectedIPN() string {
+	csvFile := m.getSelectedCSVFile()
+	cursor := m.table.Cursor()
+	dataIdx := cursor
+	if m.rowToDataIdx != nil && cursor < len(m.rowToDataIdx) {
+		dataIdx = m.rowToDataIdx[cursor]
+	}
+	if csvFile != nil {
+		ipnIdx := findHeaderIndex(csvFile.Headers, "IPN")
+		if ipnIdx >= 0 && dataIdx >= 0 && dataIdx < len(csvFile.Rows) && ipnIdx < len(csvFile.Rows[dataIdx]) {
+			return csvFile.Rows[dataIdx][ipnIdx]
+		}
+	} else if m.selectedFile == allFilesOption {
+		if dataIdx >= 0 && dataIdx < len(m.allRows) {
+			return m.allRows[dataIdx][0]
+		}
+	}
+	return ""
+}
+
+// changelogEditedMsg is sent when the editor opened on the CHANGELOG.md of a
+// release exits
+type changelogEditedMsg struct {
+	ipn    string
+	srcDir string
+	err    error
+}
+
+// startRelease releases pn. If the CHANGELOG.md of the release has no entry
+// for it, a section is added and $EDITOR is opened on the file first, and the
+// release continues when the editor exits.
+func (m *modelNew) startRelease(pn string) tea.Cmd {
+	bomPath, ymlPath, err := findReleaseSource(pn)
+	if err != nil {
+		m.error = err.Error()
+		return nil
+	}
+	srcDir := filepath.Dir(ymlPath)
+	if ymlPath == "" {
+		srcDir = filepath.Dir(bomPath)
+	}
+
+	section, err := releaseChangelogSection(srcDir, pn)
+	if err != nil {
+		m.error = "Error reading CHANGELOG.md: " + err.Error()
+		return nil
+	}
+	if section != nil {
+		m.runRelease(pn)
+		return nil
+	}
+
+	err = addChangelogSection(srcDir, pn, time.Now().Format("2006-01-02"))
+	if err != nil {
+		m.error = "Error updating CHANGELOG.md: " + err.Error()
+		return nil
+	}
+
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	args := append(editor[1:], filepath.Join(srcDir, "CHANGELOG.md"))
+	cmd := exec.Command(editor[0], args...)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return changelogEditedMsg{ipn: pn, srcDir: srcDir, err: err}
+	})
+}
+
+// runRelease processes the release of pn, writes its log file as the CLI
+// does, and shows the release log
+func (m *modelNew) runRelease(pn string) {
+	var logBuilder strings.Builder
+	srcDir, err := processRelease(pn, &logBuilder, m.pmDir)
+	if err != nil {
+		logBuilder.WriteString(fmt.Sprintf("release error: %v\n", err))
+	} else {
+		logBuilder.WriteString(fmt.Sprintf("release %v updated\n", pn))
+	}
+	if srcDir != "" {
+		if _, logErr := writeReleaseLog(pn, srcDir, logBuilder.String()); logErr != nil && err == nil {
+			err = fmt.Errorf("Error writing log file: %v", logErr)
+		}
+	}
+	m.releaseIPN = pn
+	m.releaseSrcDir = srcDir
+	m.releaseLog = logBuilder.String()
+	m.releaseError = err != nil
+	if err != nil {
+		m.releaseLog += "\nError: " + err.Error()
+	}
+	m.releaseScroll = 0
+	m.mode = modeRelease
+}
+
 // enterEditMode sets up the edit overlay for the given data row index.
 func (m *modelNew) enterEditMode(dataRowIdx int, isNew bool) {
 	csvFile := m.getSelectedCSVFile()
@@ -680,6 +786,23 @@ func (m modelNew) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		return m, nil
 
+	case changelogEditedMsg:
+		if msg.err != nil {
+			m.error = "Error running editor: " + msg.err.Error()
+			return m, nil
+		}
+		section, err := releaseChangelogSection(msg.srcDir, msg.ipn)
+		if err != nil {
+			m.error = "Error reading CHANGELOG.md: " + err.Error()
+			return m, nil
+		}
+		if section == nil {
+			m.error = fmt.Sprintf("No CHANGELOG.md entry for %s, release cancelled", msg.ipn)
+			return m, nil
+		}
+		m.runRelease(msg.ipn)
+		return m, nil
+
 	case tea.KeyMsg:
 		if m.viewState == viewStateInput {
 			switch msg.String() {
@@ -907,7 +1030,14 @@ func (m modelNew) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						csvFile := m.getSelectedCSVFile()
 						if csvFile != nil {
 							ipnIdx := findHeaderIndex(csvFile.Headers, "IPN")
-							newIPNStr, err := nextAvailableIPN(csvFile.Rows, ipnIdx)
+							// numbers used in other files and by our own
+							// parts are not handed out again
+							alloc, err := newIpnAllocator(m.pmDir)
+							if err != nil {
+								m.error = "Cannot add part: " + err.Error()
+								return m, nil
+							}
+							newIPNStr, err := nextAvailableIPN(csvFile.Rows, ipnIdx, alloc)
 							if err != nil {
 								m.error = "Cannot add part: " + err.Error()
 								return m, nil
@@ -1024,38 +1154,42 @@ func (m modelNew) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						}
 					}
 					return m, nil
-				case "r":
+				case "w":
 					if !m.listFocused {
-						var ipnVal string
-						csvFile := m.getSelectedCSVFile()
-						cursor := m.table.Cursor()
-						dataIdx := cursor
-						if m.rowToDataIdx != nil && cursor < len(m.rowToDataIdx) {
-							dataIdx = m.rowToDataIdx[cursor]
-						}
-						if csvFile != nil {
-							ipnIdx := findHeaderIndex(csvFile.Headers, "IPN")
-							if ipnIdx >= 0 && dataIdx >= 0 && dataIdx < len(csvFile.Rows) && ipnIdx < len(csvFile.Rows[dataIdx]) {
-								ipnVal = csvFile.Rows[dataIdx][ipnIdx]
+						ipnVal := m.selectedIPN()
+						if ipnVal != "" {
+							pn, err := newIpn(ipnVal)
+							if err != nil {
+								m.error = fmt.Sprintf("%s is not a valid IPN", ipnVal)
+								return m, nil
 							}
-						} else if m.selectedFile == allFilesOption {
-							if dataIdx >= 0 && dataIdx < len(m.allRows) {
-								ipnVal = m.allRows[dataIdx][0]
+							idx, err := loadBomIndex()
+							if err != nil {
+								m.error = "Error loading BOMs: " + err.Error()
+								return m, nil
+							}
+							lines, err := idx.whereUsed(pn)
+							if err != nil {
+								m.error = err.Error()
+								return m, nil
 							}
+							m.whereUsedLog = formatWhereUsed(pn, lines)
+							for _, s := range idx.skipped {
+								m.whereUsedLog += "Warning: " + s + "\n"
+							}
+							m.whereUsedScroll = 0
+							m.mode = modeWhereUsed
 						}
+					}
+					return m, nil
+				case "r":
+					if !m.listFocused {
+						ipnVal := m.selectedIPN()
 						if ipnVal != "" {
 							if isOur, err := ipn(ipnVal).isOurIPN(); err != nil || !isOur {
 								m.error = fmt.Sprintf("%s is not a releasable part", ipnVal)
 							} else {
-								var logBuilder strings.Builder
-								_, err := processRelease(ipnVal, &logBuilder, m.pmDir)
-								m.releaseLog = logBuilder.String()
-								m.releaseError = err != nil
-								if err != nil {
-									m.releaseLog += "\nError: " + err.Error()
-								}
-								m.releaseScroll = 0
-								m.mode = modeRelease
+								return m, m.startRelease(ipnVal)
 							}
 						}
 					}
@@ -1106,6 +1240,31 @@ func (m modelNew) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				case "esc", "enter":
 					m.mode = modeNormal
 					return m, nil
+				case "c":
+					if !m.releaseError {
+						msg := releaseMessage(m.releaseIPN, m.releaseSrcDir)
+						committed, err := gitCommitRelease(releaseCommitPaths(m.releaseIPN, m.releaseSrcDir), msg)
+						switch {
+						case err != nil:
+							m.releaseLog += "\nError committing release: " + err.Error()
+						case committed:
+							m.releaseLog += "\nRelease " + m.releaseIPN + " committed"
+						default:
+							m.releaseLog += "\nRelease " + m.releaseIPN + " unchanged, nothing to commit"
+						}
+					}
+					return m, nil
+				case "t":
+					if !m.releaseError {
+						msg := releaseMessage(m.releaseIPN, m.releaseSrcDir)
+						err := gitTagRelease(m.releaseIPN, "HEAD", msg)
+						if err != nil {
+							m.releaseLog += "\nError tagging release: " + err.Error()
+						} else {
+							m.releaseLog += "\nRelease " + m.releaseIPN + " tagged"
+						}
+					}
+					return m, nil
 				case "up", "k":
 					if m.releaseScroll > 0 {
 						m.releaseScroll--
@@ -1123,6 +1282,30 @@ func (m modelNew) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return m, nil
 				}
 
+			case modeWhereUsed:
+				switch msg.String() {
+				case "ctrl+c":
+					return m, tea.Quit
+				case "esc", "enter":
+					m.mode = modeNormal
+					return m, nil
+				case "up", "k":
+					if m.whereUsedScroll > 0 {
+						m.whereUsedScroll--
+					}
+					return m, nil
+				case "down", "j":
+					lines := strings.Split(m.whereUsedLog, "\n")
+					maxScroll := len(lines) - 1
+					if maxScroll < 0 {
+						maxScroll = 0
+					}
+					if m.whereUsedScroll < maxScroll {
+						m.whereUsedScroll++
+					}
+					return m, nil
+				}
+
 			case modeConfirmDelete:
 				switch msg.String() {
 				case "ctrl+c":
@@ -1391,6 +1574,8 @@ func (m modelNew) View() string {
 		case modeDetail:
 			helpText = "↑/↓: scroll • o: open datasheet • Esc: close"
 		case modeRelease:
+			helpText = "↑/↓: scroll • c: commit • t: tag • Esc: close"
+		case modeWhereUsed:
 			helpText = "↑/↓: scroll • Esc: close"
 		default:
 			hasFilter := m.searchInput.Value() != ""
@@ -1410,7 +1595,7 @@ func (m modelNew) View() string {
 			if m.selectedFile != allFilesOption {
 				parts = append(parts, "o datasheet")
 			}
-			parts = append(parts, "r release")
+			parts = append(parts, "r release", "w where used")
 			if hasFilter {
 				parts = append(parts, "Esc clear filter")
 			}
@@ -1543,7 +1728,11 @@ func (m modelNew) View() string {
 			}
 
 			releaseLines = append(releaseLines, "")
-			releaseLines = append(releaseLines, helpStyle.Render("↑/↓: scroll • Esc: close"))
+			if m.releaseError {
+				releaseLines = append(releaseLines, helpStyle.Render("↑/↓: scroll • Esc: close"))
+			} else {
+				releaseLines = append(releaseLines, helpStyle.Render("↑/↓: scroll • c: commit • t: tag • Esc: close"))
+			}
 
 			borderColor := lipgloss.Color("34") // green
 			if m.releaseError {
@@ -1557,6 +1746,38 @@ func (m modelNew) View() string {
 			components = append(components, overlay)
 		}
 
+		// Where-used overlay
+		if m.mode == modeWhereUsed {
+			var whereUsedLines []string
+			whereUsedLines = append(whereUsedLines, lipgloss.NewStyle().Bold(true).Render("Where Used"))
+			whereUsedLines = append(whereUsedLines, "")
+
+			logLines := strings.Split(m.whereUsedLog, "\n")
+			visibleLines := m.height - 10
+			if visibleLines < 5 {
+				visibleLines = 5
+			}
+
+			end := m.whereUsedScroll + visibleLines
+			if end > len(logLines) {
+				end = len(logLines)
+			}
+
+			for i := m.whereUsedScroll; i < end; i++ {
+				whereUsedLines = append(whereUsedLines, logLines[i])
+			}
+
+			whereUsedLines = append(whereUsedLines, "")
+			whereUsedLines = append(whereUsedLines, helpStyle.Render("↑/↓: scroll • Esc: close"))
+
+			overlay := lipgloss.NewStyle().
+				BorderStyle(lipgloss.RoundedBorder()).
+				BorderForeground(lipgloss.Color("33")).
+				Padding(1, 2).
+				Render(strings.Join(whereUsedLines, "\n"))
+			components = append(components, overlay)
+		}
+
 		components = append(components, help)
 		content := lipgloss.JoinVertical(lipgloss.Top, components...)
 
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// whereUsedLine is one assembly that uses a part
+type whereUsedLine struct {
+	// Assembly is the IPN of a released BOM, or CCC-NNN(-VV) of a source BOM
+	Assembly string
+	Path     string
+	// Qty is the number of parts used in one Assembly
+	Qty float64
+	// Ref are the reference designators of the part in the BOM that uses it
+	// directly
+	Ref string
+	// Via lists the sub-assemblies the part is used through, from Assembly
+	// down to the one that uses it directly. It is empty for direct use.
+	Via []string
+}
+
+// bomIndex holds every BOM found in the source tree, keyed by file name
+// without the extension.
+type bomIndex struct {
+	paths map[string]string
+	boms  map[string]bom
+	// skipped lists the BOMs that could not be read, and why
+	skipped []string
+}
+
+// loadBomIndex reads every BOM in the source tree. A BOM that cannot be read
+// is skipped and listed in skipped, so the others can still be queried.
+func loadBomIndex() (*bomIndex, error) {
+	paths, err := findBomFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &bomIndex{paths: paths, boms: map[string]bom{}}
+	names := lo.Keys(paths)
+	sort.Strings(names)
+	for _, name := range names {
+		b := bom{}
+		err := loadCSV(paths[name], &b)
+		if err != nil {
+			idx.skipped = append(idx.skipped,
+				fmt.Sprintf("skipped BOM %v: %v", paths[name], err))
+			delete(idx.paths, name)
+			continue
+		}
+		idx.boms[name] = b.withoutAlternates()
+	}
+
+	return idx, nil
+}
+
+// names returns the BOM names in sorted order
+func (idx *bomIndex) names() []string {
+	ret := make([]string, 0, len(idx.boms))
+	for name := range idx.boms {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// uses reports whether a line of a BOM refers to the assembly described by
+// the BOM named name. A released BOM is used by its exact IPN. A source BOM
+// is used by any of its IPNs that has not been released, as that is the BOM
+// it will be released with.
+func (idx *bomIndex) uses(l *bomLine, name string) bool {
+	if string(l.IPN) == name {
+		return true
+	}
+
+	if _, released := idx.boms[string(l.IPN)]; released {
+		return false
+	}
+
+	groups := reSourceBase.FindStringSubmatch(name)
+	if groups == nil {
+		return false
+	}
+
+	_, _, v, err := l.IPN.parse()
+	if err != nil {
+		return false
+	}
+
+	if groups[3] == "" {
+		return l.IPN.base() == name
+	}
+
+	return l.IPN.base()+"-"+variationPrefix(v) == name
+}
+
+// whereUsed returns every assembly that uses pn, either directly or through
+// PCA/ASY sub-assemblies, sorted by assembly.
+func (idx *bomIndex) whereUsed(pn ipn) ([]whereUsedLine, error) {
+	ret := []whereUsedLine{}
+
+	for _, name := range idx.names() {
+		qty := 0.0
+		refs := []string{}
+		for _, l := range idx.boms[name] {
+			if l.IPN == pn {
+				qty += l.Qty
+				if l.Ref != "" {
+					refs = append(refs, l.Ref)
+				}
+			}
+		}
+		if qty == 0 && len(refs) == 0 {
+			continue
+		}
+
+		direct := whereUsedLine{
+			Assembly: name,
+			Path:     idx.paths[name],
+			Qty:      qty,
+			Ref:      sortReferenceDesignators(strings.Join(refs, " ")),
+		}
+		ret = append(ret, direct)
+
+		parents, err := idx.usedThrough(direct, []ipn{ipn(name)})
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, parents...)
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Assembly < ret[j].Assembly
+	})
+
+	return ret, nil
+}
+
+// usedThrough returns the assemblies that use the sub-assembly at the top of
+// path, scaling the quantity of use u by the number of sub-assemblies used.
+// path is the chain of assemblies from that sub-assembly down to the one that
+// uses the part directly.
+func (idx *bomIndex) usedThrough(u whereUsedLine, path []ipn) ([]whereUsedLine, error) {
+	ret := []whereUsedLine{}
+	sub := path[0]
+
+	for _, name := range idx.names() {
+		qty := 0.0
+		for _, l := range idx.boms[name] {
+			if isSub, _ := l.IPN.hasBOM(); isSub && idx.uses(l, string(sub)) {
+				qty += l.Qty
+			}
+		}
+		if qty == 0 {
+			continue
+		}
+
+		parentPath := append([]ipn{ipn(name)}, path...)
+		if lo.Contains(path, ipn(name)) {
+			return nil, fmt.Errorf("BOM cycle: %v",
+				formatBomPath(parentPath[:lo.IndexOf(path, ipn(name))+2]))
+		}
+		if maxBomDepth > 0 && len(parentPath) > maxBomDepth {
+			return nil, fmt.Errorf("BOM is more than %v levels deep: %v",
+				maxBomDepth, formatBomPath(parentPath))
+		}
+
+		parent := whereUsedLine{
+			Assembly: name,
+			Path:     idx.paths[name],
+			Qty:      u.Qty * qty,
+			Ref:      u.Ref,
+			Via:      append([]string{string(sub)}, u.Via...),
+		}
+		ret = append(ret, parent)
+
+		parents, err := idx.usedThrough(parent, parentPath)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, parents...)
+	}
+
+	return ret, nil
+}
+
+// formatWhereUsed renders the where-used report as text
+func formatWhereUsed(pn ipn, lines []whereUsedLine) string {
+	if len(lines) == 0 {
+		return fmt.Sprintf("%v is not used in any BOM\n", pn)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "%v is used in:\n", pn)
+	for _, l := range lines {
+		fmt.Fprintf(&out, "  %v: qty %v", l.Assembly, l.Qty)
+		if len(l.Via) > 0 {
+			fmt.Fprintf(&out, " via %v", strings.Join(l.Via, " -> "))
+		}
+		if l.Ref != "" {
+			fmt.Fprintf(&out, ", refs %v", l.Ref)
+		}
+		fmt.Fprintf(&out, " (%v)\n", l.Path)
+	}
+
+	return out.String()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWhereUsed(t *testing.T) {
+	pmDir := setupReleaseTree(t)
+
+	type use struct {
+		assembly string
+		qty      float64
+		ref      string
+		via      []string
+	}
+
+	check := func(pn ipn, exp []use) {
+		t.Helper()
+		idx, err := loadBomIndex()
+		if err != nil {
+			t.Fatalf("loadBomIndex() error: %v", err)
+		}
+		lines, err := idx.whereUsed(pn)
+		if err != nil {
+			t.Fatalf("whereUsed(%v) error: %v", pn, err)
+		}
+		got := []use{}
+		for _, l := range lines {
+			got = append(got, use{l.Assembly, l.Qty, l.Ref, l.Via})
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("whereUsed(%v) = %+v, want %+v", pn, got, exp)
+		}
+	}
+
+	// nothing released yet, so the source BOMs are used
+	check("RES-001-1002", []use{
+		{"ASY-001", 4, "R1 R2", []string{"PCA-019"}},
+		{"PCA-019", 2, "R1 R2", nil},
+	})
+
+	release(t, "PCB-019-0001", pmDir)
+	release(t, "PCA-019-0000", pmDir)
+	release(t, "ASY-001-0000", pmDir)
+
+	// the assemblies now use the released PCA-019-0000
+	check("RES-001-1002", []use{
+		{"ASY-001", 4, "R1 R2", []string{"PCA-019-0000"}},
+		{"ASY-001-0000", 4, "R1 R2", []string{"PCA-019-0000"}},
+		{"PCA-019", 2, "R1 R2", nil},
+		{"PCA-019-0000", 2, "R1 R2", nil},
+	})
+
+	check("SCR-002-0002", []use{
+		{"ASY-001", 4, "", nil},
+		{"ASY-001-0000", 4, "", nil},
+	})
+
+	check("CAP-000-0001", []use{})
+
+	// a BOM that cannot be read is skipped, and the others still used
+	writeTree(t, ".", map[string]string{"ASY-003.csv": "IPN,Qty\nSCR-002-0002,1,extra\n"})
+	idx, err := loadBomIndex()
+	if err != nil {
+		t.Fatalf("loadBomIndex() with a bad BOM: %v", err)
+	}
+	if len(idx.skipped) != 1 || !strings.Contains(idx.skipped[0], "ASY-003.csv") {
+		t.Errorf("skipped = %v, want ASY-003.csv", idx.skipped)
+	}
+	check("SCR-002-0002", []use{
+		{"ASY-001", 4, "", nil},
+		{"ASY-001-0000", 4, "", nil},
+	})
+}